@@ -0,0 +1,188 @@
+package parallexe
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+)
+
+type BecomeMethod string
+
+const (
+	BecomeSudo BecomeMethod = "sudo"
+	BecomeSu   BecomeMethod = "su"
+	BecomeDoas BecomeMethod = "doas"
+)
+
+const (
+	// becomeStartMarker is printed by the commands run with a become password when they start,
+	// once the password has been asked or not (e.g. NOPASSWD sudo rule, su run as root)
+	becomeStartMarker = "parallexe-become-started"
+	// sudoPasswordPrompt is the prompt printed by sudo on stderr when it reads the password
+	sudoPasswordPrompt = "parallexe-sudo-password:"
+)
+
+var (
+	// passwordPromptPattern matches the password prompts of su and doas
+	passwordPromptPattern = regexp.MustCompile(`(?i)password[^\n]*:\s*$`)
+	// sudoPasswordPromptPattern matches sudoPasswordPrompt
+	sudoPasswordPromptPattern = regexp.MustCompile(regexp.QuoteMeta(sudoPasswordPrompt) + `$`)
+)
+
+// becomeCommand wraps cmd to run it as execConfig.BecomeUser with execConfig.BecomeMethod.
+// cmd is returned as is if execConfig.Become is false.
+// With a password, cmd prints becomeStartMarker when it starts (on stderr for sudo, in the PTY for su and doas).
+func becomeCommand(cmd string, execConfig *ExecConfig) (string, error) {
+	if execConfig == nil || !execConfig.Become {
+		return cmd, nil
+	}
+
+	user := execConfig.BecomeUser
+	if user == "" {
+		user = "root"
+	}
+	if !userGroupPattern.MatchString(user) {
+		return "", fmt.Errorf("invalid become user %q", user)
+	}
+
+	switch execConfig.becomeMethod() {
+	case BecomeSudo:
+		if execConfig.BecomePassword != "" {
+			// -k ignores cached credentials so the password is asked unless a rule allows the command without it,
+			// -p sets a prompt detected by passwordPromptWriter
+			startedCmd := fmt.Sprintf("echo %s >&2; %s", becomeStartMarker, cmd)
+			return fmt.Sprintf("sudo -k -S -p %s -u %s -- sh -c %s", ShellQuote(sudoPasswordPrompt), user, ShellQuote(startedCmd)), nil
+		}
+		return fmt.Sprintf("sudo -n -u %s -- sh -c %s", user, ShellQuote(cmd)), nil
+	case BecomeSu:
+		if execConfig.BecomePassword != "" {
+			cmd = fmt.Sprintf("echo %s; %s", becomeStartMarker, cmd)
+		}
+		return fmt.Sprintf("su %s -c %s", user, ShellQuote(cmd)), nil
+	case BecomeDoas:
+		if execConfig.BecomePassword != "" {
+			startedCmd := fmt.Sprintf("echo %s; %s", becomeStartMarker, cmd)
+			return fmt.Sprintf("doas -u %s -- sh -c %s", user, ShellQuote(startedCmd)), nil
+		}
+		return fmt.Sprintf("doas -n -u %s -- sh -c %s", user, ShellQuote(cmd)), nil
+	}
+
+	return "", fmt.Errorf("unknown become method %q", execConfig.BecomeMethod)
+}
+
+// becomeMethod returns the privilege escalation method, BecomeSudo by default
+func (execConfig *ExecConfig) becomeMethod() BecomeMethod {
+	if execConfig.BecomeMethod == "" {
+		return BecomeSudo
+	}
+
+	return execConfig.BecomeMethod
+}
+
+// becomePassword returns true if the commands are run with a become password, detected by a passwordPromptWriter
+func becomePassword(execConfig *ExecConfig) bool {
+	return execConfig != nil && execConfig.Become && execConfig.BecomePassword != ""
+}
+
+// becomePasswordOnStdin returns true if the become password is written on stdin when sudo prompts for it on stderr
+func becomePasswordOnStdin(execConfig *ExecConfig) bool {
+	return execConfig != nil && execConfig.Become && execConfig.BecomePassword != "" && execConfig.becomeMethod() == BecomeSudo
+}
+
+// becomePasswordOnPrompt returns true if the become password must be typed in a PTY when it is prompted (su, doas)
+func becomePasswordOnPrompt(execConfig *ExecConfig) bool {
+	return execConfig != nil && execConfig.Become && execConfig.BecomePassword != "" && execConfig.becomeMethod() != BecomeSudo
+}
+
+// newPasswordPromptWriter returns the passwordPromptWriter of a command run with a become password:
+// it watches stderr for sudo, and the PTY output (stdout) for su and doas
+func newPasswordPromptWriter(execConfig *ExecConfig, stdout io.Writer, stderr io.Writer, stdin io.WriteCloser, input io.Reader) *passwordPromptWriter {
+	if becomePasswordOnStdin(execConfig) {
+		return &passwordPromptWriter{output: stderr, stdin: stdin, password: execConfig.BecomePassword, input: input, prompt: sudoPasswordPromptPattern}
+	}
+
+	return &passwordPromptWriter{output: stdout, stdin: stdin, password: execConfig.BecomePassword, input: input, prompt: passwordPromptPattern}
+}
+
+// passwordPromptWriter wraps the output of a command run with a become password.
+// The output is held until becomeStartMarker is printed by the command: the held output (prompt, line feed
+// typed after the password) is then dropped, and the command input, if any, is copied to stdin, which is then closed.
+// When a password prompt is detected before, the password is written to stdin. If it is prompted again,
+// stdin is closed so that the become method fails instead of waiting for a password.
+type passwordPromptWriter struct {
+	output   io.Writer
+	stdin    io.WriteCloser
+	password string
+	input    io.Reader
+	prompt   *regexp.Regexp
+
+	m        sync.Mutex
+	buffer   bytes.Buffer
+	answered bool
+	started  bool
+}
+
+func (w *passwordPromptWriter) Write(data []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.started {
+		_, err := w.output.Write(data)
+		return len(data), err
+	}
+
+	w.buffer.Write(data)
+	held := w.buffer.Bytes()
+
+	if index := bytes.Index(held, []byte(becomeStartMarker)); index >= 0 {
+		w.started = true
+		w.copyInput()
+
+		output := bytes.TrimPrefix(bytes.TrimPrefix(held[index+len(becomeStartMarker):], []byte("\r")), []byte("\n"))
+		_, err := w.output.Write(output)
+		w.buffer.Reset()
+		return len(data), err
+	}
+
+	location := w.prompt.FindIndex(held)
+	if location == nil {
+		return len(data), nil
+	}
+	// Remove the prompt, and keep the messages printed before it (e.g. "Sorry, try again.")
+	w.buffer.Truncate(location[0])
+
+	if w.answered {
+		w.stdin.Close()
+		return len(data), nil
+	}
+
+	w.answered = true
+	if _, err := io.WriteString(w.stdin, w.password+"\n"); err != nil {
+		return 0, fmt.Errorf("can't send become password: %v", err)
+	}
+
+	return len(data), nil
+}
+
+// copyInput copies the command input to stdin in the background, then closes stdin
+func (w *passwordPromptWriter) copyInput() {
+	go func() {
+		if w.input != nil {
+			_, _ = io.Copy(w.stdin, w.input)
+		}
+		w.stdin.Close()
+	}()
+}
+
+// Flush writes the held output if the command has not started
+func (w *passwordPromptWriter) Flush() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	_, err := w.output.Write(w.buffer.Bytes())
+	w.buffer.Reset()
+
+	return err
+}
@@ -0,0 +1,257 @@
+package parallexe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBecomeCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		execConfig *ExecConfig
+		expected   string
+	}{
+		{"No config", nil, "id -un"},
+		{"No become", &ExecConfig{BecomeUser: "postgres"}, "id -un"},
+		{"Sudo", &ExecConfig{Become: true}, "sudo -n -u root -- sh -c 'id -un'"},
+		{"Sudo with password", &ExecConfig{Become: true, BecomeUser: "postgres", BecomePassword: "secret"}, "sudo -k -S -p parallexe-sudo-password: -u postgres -- sh -c 'echo parallexe-become-started >&2; id -un'"},
+		{"Su", &ExecConfig{Become: true, BecomeMethod: BecomeSu, BecomeUser: "postgres"}, "su postgres -c 'id -un'"},
+		{"Su with password", &ExecConfig{Become: true, BecomeMethod: BecomeSu, BecomePassword: "secret"}, "su root -c 'echo parallexe-become-started; id -un'"},
+		{"Doas", &ExecConfig{Become: true, BecomeMethod: BecomeDoas}, "doas -n -u root -- sh -c 'id -un'"},
+		{"Doas with password", &ExecConfig{Become: true, BecomeMethod: BecomeDoas, BecomePassword: "secret"}, "doas -u root -- sh -c 'echo parallexe-become-started; id -un'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := becomeCommand("id -un", test.execConfig)
+			if err != nil {
+				t.Fatalf("Error during become command creation: %v", err)
+			}
+			if result != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, result)
+			}
+		})
+	}
+
+	t.Run("Invalid user", func(t *testing.T) {
+		if _, err := becomeCommand("id", &ExecConfig{Become: true, BecomeUser: "root; id"}); err == nil {
+			t.Errorf("Expected an error for an invalid user")
+		}
+	})
+
+	t.Run("Unknown method", func(t *testing.T) {
+		if _, err := becomeCommand("id", &ExecConfig{Become: true, BecomeMethod: "runas"}); err == nil {
+			t.Errorf("Expected an error for an unknown method")
+		}
+	})
+}
+
+// stdinRecorder records the data written to stdin and whether it has been closed
+type stdinRecorder struct {
+	m      sync.Mutex
+	data   strings.Builder
+	closed chan struct{}
+}
+
+func newStdinRecorder() *stdinRecorder {
+	return &stdinRecorder{closed: make(chan struct{})}
+}
+
+func (r *stdinRecorder) Write(data []byte) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.data.Write(data)
+}
+
+func (r *stdinRecorder) Close() error {
+	close(r.closed)
+	return nil
+}
+
+// String waits for stdin to be closed and returns the data written to it
+func (r *stdinRecorder) String(t *testing.T) string {
+	select {
+	case <-r.closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected stdin to be closed")
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return r.data.String()
+}
+
+func TestPasswordPromptWriter(t *testing.T) {
+	var output strings.Builder
+	stdin := newStdinRecorder()
+	writer := &passwordPromptWriter{output: &output, stdin: stdin, password: "secret", input: strings.NewReader("input\n"), prompt: passwordPromptPattern}
+
+	writer.Write([]byte("Pass"))
+	writer.Write([]byte("word: "))
+	writer.Write([]byte("\r\nparallexe-become-"))
+	writer.Write([]byte("started\r\nroot\n"))
+	writer.Flush()
+
+	if data := stdin.String(t); data != "secret\ninput\n" {
+		t.Errorf("Expected password then input to be sent, got %q", data)
+	}
+	if output.String() != "root\n" {
+		t.Errorf("Expected prompt to be removed from output, got %q", output.String())
+	}
+
+	t.Run("Without prompt", func(t *testing.T) {
+		var output strings.Builder
+		stdin := newStdinRecorder()
+		writer := &passwordPromptWriter{output: &output, stdin: stdin, password: "secret", input: strings.NewReader("input\n"), prompt: sudoPasswordPromptPattern}
+
+		writer.Write([]byte("parallexe-become-started\nroot\n"))
+		writer.Flush()
+
+		if data := stdin.String(t); data != "input\n" {
+			t.Errorf("Expected only the input to be sent, got %q", data)
+		}
+		if output.String() != "root\n" {
+			t.Errorf("Expected output to be kept, got %q", output.String())
+		}
+	})
+
+	t.Run("Rejected password", func(t *testing.T) {
+		var output strings.Builder
+		stdin := newStdinRecorder()
+		writer := &passwordPromptWriter{output: &output, stdin: stdin, password: "wrong", prompt: sudoPasswordPromptPattern}
+
+		writer.Write([]byte("parallexe-sudo-password:"))
+		writer.Write([]byte("Sorry, try again.\nparallexe-sudo-password:"))
+		writer.Write([]byte("sudo: no password was provided\n"))
+		writer.Flush()
+
+		if data := stdin.String(t); data != "wrong\n" {
+			t.Errorf("Expected password to be sent once, got %q", data)
+		}
+		if output.String() != "Sorry, try again.\nsudo: no password was provided\n" {
+			t.Errorf("Expected prompts to be removed from output, got %q", output.String())
+		}
+	})
+}
+
+func TestExecBecomePassword(t *testing.T) {
+	// Fake a sudo asking the password, unless FAKE_SUDO_NOPASSWD is set, then running the command
+	binDir, err := os.MkdirTemp("", "parallexe-sudo")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(binDir)
+
+	fakeSudo := `#!/bin/sh
+while [ "$1" != "--" ]; do
+  [ "$1" = -p ] && { prompt=$2; shift; }
+  shift
+done
+shift
+if [ -z "$FAKE_SUDO_NOPASSWD" ]; then
+  printf '%s' "$prompt" >&2
+  read -r password
+  [ "$password" = secret ] || { echo "sudo: wrong password" >&2; exit 1; }
+fi
+exec "$@"
+`
+	if err := os.WriteFile(filepath.Join(binDir, "sudo"), []byte(fakeSudo), 0755); err != nil {
+		t.Fatalf("Error during fake sudo creation: %v", err)
+	}
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	for name, nopasswd := range map[string]string{"Password asked": "", "NOPASSWD rule": "1"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("FAKE_SUDO_NOPASSWD", nopasswd)
+
+			responses, err := pexe.Exec("cat", &ExecConfig{Become: true, BecomePassword: "secret", Stdin: strings.NewReader("SELECT 1;\n")})
+			if err != nil {
+				t.Fatalf("Error during Exec: %v", err)
+			}
+
+			if responses.HostResponses["localhost"].Stdout != "SELECT 1;\n" {
+				t.Errorf("Expected only the input to be read by the command, got %q", responses.HostResponses["localhost"].Stdout)
+			}
+		})
+	}
+
+	t.Run("Command reading stdin without input", func(t *testing.T) {
+		t.Setenv("FAKE_SUDO_NOPASSWD", "1")
+
+		responses, err := pexe.Exec("cat", &ExecConfig{Become: true, BecomePassword: "secret"})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "" {
+			t.Errorf("Expected no input, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+	})
+}
+
+func TestExecBecome(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("su without password requires to run as root")
+	}
+
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	execConfig := &ExecConfig{Become: true, BecomeMethod: BecomeSu, BecomeUser: "root"}
+
+	response, err := pexe.Exec("id -un", execConfig)
+	if err != nil {
+		t.Fatalf("Error during Exec: %v", err)
+	}
+	if response.HostResponses["localhost"].Stdout != "root\n" {
+		t.Errorf("Expected command to run as root, got %q", response.HostResponses["localhost"].Stdout)
+	}
+
+	t.Run("Send as become user", func(t *testing.T) {
+		file, err := os.CreateTemp("", "testfile")
+		if err != nil {
+			t.Fatalf("Error during file test creation: %v", err)
+		}
+		defer os.Remove(file.Name())
+		file.WriteString("it's\n")
+
+		destCopyFile := fmt.Sprintf("%s/%s", os.TempDir(), "file")
+		defer os.Remove(destCopyFile)
+
+		_, err = pexe.Send(file.Name(), destCopyFile, &SendConfig{ExecConfig: execConfig, Mode: 0600})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+
+		content, err := os.ReadFile(destCopyFile)
+		if err != nil {
+			t.Fatalf("Error during file test reading: %v", err)
+		}
+		if string(content) != "it's\n" {
+			t.Fatalf("File content is not correct")
+		}
+	})
+
+	t.Run("Password with prompt is not supported locally", func(t *testing.T) {
+		_, err := pexe.Exec("id -un", &ExecConfig{Become: true, BecomeMethod: BecomeSu, BecomePassword: "secret"})
+		if err == nil {
+			t.Errorf("Expected an error for su with a password on localhost")
+		}
+	})
+}
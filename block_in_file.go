@@ -0,0 +1,164 @@
+package parallexe
+
+import "strings"
+
+// DefaultBlockMarker is the default format of the lines surrounding a block managed by BlockInFile
+const DefaultBlockMarker = "# {mark} parallexe {name}"
+
+type BlockInFileConfig struct {
+	// ExecConfig allows to filter hosts and groups
+	ExecConfig *ExecConfig
+	// Name identifies the block in the file. It replaces {name} in Marker,
+	// so several blocks can be managed in the same file.
+	Name string
+	// Marker is the format of the lines surrounding the block, {mark} being replaced by MarkerBegin or MarkerEnd
+	// and {name} by Name. Default is DefaultBlockMarker.
+	Marker string
+	// MarkerBegin replaces {mark} in the opening marker line. Default is "BEGIN".
+	MarkerBegin string
+	// MarkerEnd replaces {mark} in the closing marker line. Default is "END".
+	MarkerEnd string
+	// Absent removes the block and its markers. An empty block is also removed.
+	Absent bool
+	// InsertAfter is a regular expression: a new block is inserted after the last matching line.
+	// InsertEOF (default) inserts the block at the end of the file, as well as when no line matches.
+	// An existing block is updated in place.
+	InsertAfter string
+	// InsertBefore is a regular expression: a new block is inserted before the last matching line.
+	// InsertBOF inserts the block at the beginning of the file. If no line matches, the block is inserted at the end of the file.
+	InsertBefore string
+	// NoCreate returns an error for hosts where the file does not exist, instead of creating it with the block
+	NoCreate bool
+	// Backup copies the file before changing it. The path of the backup file is returned in CommandResponse.Stdout.
+	Backup bool
+	// CompileTemplate If block is a go template, Parallexe will compile it for each host with ExecVariables
+	CompileTemplate bool
+	// ExecVariables contains the runtime variables for compiling the block template
+	ExecVariables *ExecVariables
+}
+
+// BlockInFile ensures a multi-line block surrounded by marker lines is present in a file.
+// If the markers are found, the block between them is replaced, otherwise the block and its markers are inserted
+// as defined by config.InsertAfter and config.InsertBefore.
+// If config.Absent is true or block is empty, the block and its markers are removed.
+// If the file does not exist, it will be created with the block, unless config.NoCreate is true.
+// CommandResponse.Changed and CommandResponse.Diff report the changes per host.
+func (p *Parallexe) BlockInFile(path string, block string, config *BlockInFileConfig) (*CommandResponses, error) {
+	rules, err := compileLineInFileRules(&LineInFileConfig{
+		InsertAfter:  config.InsertAfter,
+		InsertBefore: config.InsertBefore,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blockContent := func(hostConnection HostConnection) (string, error) {
+		return block, nil
+	}
+
+	if config.CompileTemplate {
+		tmpl, err := parseTextTemplate(strings.TrimSpace("block "+config.Name), block)
+		if err != nil {
+			return nil, err
+		}
+
+		blockContent = func(hostConnection HostConnection) (string, error) {
+			variables, err := templateVariables(hostConnection, config.ExecVariables)
+			if err != nil {
+				return "", err
+			}
+			return renderTemplate(tmpl, hostConnection.HostConfig.Host, variables)
+		}
+	}
+
+	// White list HostSession to execute only on desired hosts
+	filteredHosts := getFilteredHosts(p.HostConnections, config.ExecConfig)
+
+	options := editFileOptions{Create: !config.NoCreate, Backup: config.Backup}
+
+	return editFileOnHosts(filteredHosts, path, options, config.ExecConfig, func(hostConnection HostConnection, content string, exists bool) (string, error) {
+		hostBlock, err := blockContent(hostConnection)
+		if err != nil {
+			return "", err
+		}
+
+		return editBlockInFile(content, hostBlock, config, rules), nil
+	})
+}
+
+// blockMarker returns the marker line for mark
+func (config *BlockInFileConfig) blockMarker(mark string) string {
+	marker := config.Marker
+	if marker == "" {
+		marker = DefaultBlockMarker
+	}
+
+	marker = strings.ReplaceAll(marker, "{mark}", mark)
+	marker = strings.ReplaceAll(marker, "{name}", config.Name)
+
+	return strings.TrimSpace(marker)
+}
+
+// beginEndMarkers returns the opening and closing marker lines of the block
+func (config *BlockInFileConfig) beginEndMarkers() (string, string) {
+	markerBegin := config.MarkerBegin
+	if markerBegin == "" {
+		markerBegin = "BEGIN"
+	}
+
+	markerEnd := config.MarkerEnd
+	if markerEnd == "" {
+		markerEnd = "END"
+	}
+
+	return config.blockMarker(markerBegin), config.blockMarker(markerEnd)
+}
+
+// editBlockInFile returns content edited as defined by config.
+// The returned content is unchanged if the block is already as expected.
+func editBlockInFile(content string, block string, config *BlockInFileConfig, rules *lineInFileRules) string {
+	lines := splitFileLines(content)
+	beginMarker, endMarker := config.beginEndMarkers()
+
+	// Find the existing block
+	beginIndex, endIndex := -1, -1
+	for index, line := range lines {
+		if beginIndex < 0 && line == beginMarker {
+			beginIndex = index
+		} else if beginIndex >= 0 && line == endMarker {
+			endIndex = index
+			break
+		}
+	}
+	found := beginIndex >= 0 && endIndex >= 0
+
+	blockLines := make([]string, 0)
+	if !config.Absent && block != "" {
+		blockLines = append(blockLines, beginMarker)
+		blockLines = append(blockLines, splitFileLines(block)...)
+		blockLines = append(blockLines, endMarker)
+	}
+
+	var newLines []string
+	if found {
+		newLines = append(newLines, lines[:beginIndex]...)
+		newLines = append(newLines, blockLines...)
+		newLines = append(newLines, lines[endIndex+1:]...)
+	} else {
+		if len(blockLines) == 0 {
+			return content
+		}
+
+		index := insertionIndex(lines, rules)
+		newLines = append(newLines, lines[:index]...)
+		newLines = append(newLines, blockLines...)
+		newLines = append(newLines, lines[index:]...)
+	}
+
+	newContent := joinFileLines(newLines)
+	if newContent == joinFileLines(lines) {
+		return content
+	}
+
+	return newContent
+}
@@ -0,0 +1,170 @@
+package parallexe
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestEditBlockInFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		block    string
+		config   BlockInFileConfig
+		expected string
+	}{
+		{
+			"Insert block",
+			"a\n",
+			"b\nc\n",
+			BlockInFileConfig{Name: "test"},
+			"a\n# BEGIN parallexe test\nb\nc\n# END parallexe test\n",
+		},
+		{
+			"Update block",
+			"a\n# BEGIN parallexe test\nold\n# END parallexe test\nz\n",
+			"b\nc",
+			BlockInFileConfig{Name: "test"},
+			"a\n# BEGIN parallexe test\nb\nc\n# END parallexe test\nz\n",
+		},
+		{
+			"Block already present",
+			"a\n# BEGIN parallexe test\nb\n# END parallexe test",
+			"b",
+			BlockInFileConfig{Name: "test"},
+			"a\n# BEGIN parallexe test\nb\n# END parallexe test",
+		},
+		{
+			"Keep other blocks",
+			"# BEGIN parallexe other\nx\n# END parallexe other\n",
+			"b",
+			BlockInFileConfig{Name: "test"},
+			"# BEGIN parallexe other\nx\n# END parallexe other\n# BEGIN parallexe test\nb\n# END parallexe test\n",
+		},
+		{
+			"Remove block",
+			"a\n# BEGIN parallexe test\nb\n# END parallexe test\nz\n",
+			"b",
+			BlockInFileConfig{Name: "test", Absent: true},
+			"a\nz\n",
+		},
+		{
+			"Remove block with empty block",
+			"a\n# BEGIN parallexe test\nb\n# END parallexe test\n",
+			"",
+			BlockInFileConfig{Name: "test"},
+			"a\n",
+		},
+		{
+			"Remove missing block",
+			"a",
+			"b",
+			BlockInFileConfig{Name: "test", Absent: true},
+			"a",
+		},
+		{
+			"Custom markers",
+			"127.0.0.1 localhost\n",
+			"10.0.0.1 db",
+			BlockInFileConfig{Marker: "## {mark} hosts", MarkerBegin: "START", MarkerEnd: "STOP"},
+			"127.0.0.1 localhost\n## START hosts\n10.0.0.1 db\n## STOP hosts\n",
+		},
+		{
+			"Insert before",
+			"Port 22\nMatch User git\n",
+			"PasswordAuthentication no",
+			BlockInFileConfig{InsertBefore: "^Match "},
+			"Port 22\n# BEGIN parallexe\nPasswordAuthentication no\n# END parallexe\nMatch User git\n",
+		},
+		{
+			"Insert at beginning",
+			"a\n",
+			"b",
+			BlockInFileConfig{InsertBefore: InsertBOF},
+			"# BEGIN parallexe\nb\n# END parallexe\na\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := compileLineInFileRules(&LineInFileConfig{InsertAfter: test.config.InsertAfter, InsertBefore: test.config.InsertBefore})
+			if err != nil {
+				t.Fatalf("Error during rules compilation: %v", err)
+			}
+
+			result := editBlockInFile(test.content, test.block, &test.config, rules)
+			if result != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestBlockInFile(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost", Groups: []string{"prod"}}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	file, err := os.CreateTemp("", "hosts")
+	if err != nil {
+		t.Fatalf("Error during file test creation: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("127.0.0.1 localhost\n")
+
+	config := &BlockInFileConfig{
+		Name:            "db",
+		CompileTemplate: true,
+		ExecVariables: &ExecVariables{
+			GroupVariables: map[string]KeyValueVariable{
+				"prod": {"DbIp": "10.0.0.1"},
+			},
+		},
+	}
+
+	response, err := pexe.BlockInFile(file.Name(), "{{ .DbIp }} db\n", config)
+	if err != nil {
+		t.Fatalf("Error during BlockInFile: %v", err)
+	}
+	if !response.HostResponses["localhost"].Changed {
+		t.Errorf("Expected file to be changed")
+	}
+
+	content, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("Error during file test reading: %v", err)
+	}
+	expected := "127.0.0.1 localhost\n# BEGIN parallexe db\n10.0.0.1 db\n# END parallexe db\n"
+	if string(content) != expected {
+		t.Fatalf("File content is not correct, got %q", string(content))
+	}
+
+	// Second call must not change the file
+	response, err = pexe.BlockInFile(file.Name(), "{{ .DbIp }} db\n", config)
+	if err != nil {
+		t.Fatalf("Error during BlockInFile: %v", err)
+	}
+	if response.HostResponses["localhost"].Changed {
+		t.Errorf("Expected file not to be changed")
+	}
+
+	// Block can't be added to a file that does not exist with NoCreate
+	fakeFile := fmt.Sprintf("%s/%s", os.TempDir(), "doesnotexist")
+	_, err = pexe.BlockInFile(fakeFile, "a", &BlockInFileConfig{NoCreate: true})
+	if err == nil {
+		t.Fatalf("BlockInFile should fail on a file that does not exist")
+	}
+
+	// The file is created by default
+	defer os.Remove(fakeFile)
+	_, err = pexe.BlockInFile(fakeFile, "a", &BlockInFileConfig{})
+	if err != nil {
+		t.Fatalf("Error during BlockInFile: %v", err)
+	}
+	if content, _ := os.ReadFile(fakeFile); string(content) != "# BEGIN parallexe\na\n# END parallexe\n" {
+		t.Errorf("Expected file to be created with the block, got %q", string(content))
+	}
+}
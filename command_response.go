@@ -1,15 +1,48 @@
 package parallexe
 
-import "strings"
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
 
 type CommandResponse struct {
 	Stdout string
 	// Contains the error returns by the command
 	Stderr string
-	// Contains network error (ssh connection, ...)
+	// Contains network error (ssh connection, ...), or the exit code of a command run in a PTY, whose stderr is merged into Stdout
 	Error   error
 	Code    int
 	Success bool
+	// Changed indicates whether the operation changed (or would change, in check mode) something on the host
+	Changed bool
+	// Diff contains the unified diff of the changes made (or that would be made, in check mode) on the host
+	Diff string
+	// Attempts is the number of times the command has been run (see RetryConfig)
+	Attempts int
+	// Command is the last command run on the host, wrapped by the shell and become commands (see ExecConfig),
+	// and redacted as the output. The content of the files written by Send, LineInFile, BlockInFile and Script
+	// is replaced by its size. It is empty if no command has been run.
+	Command string
+	// HostConfig is the configuration of the host, with its dynamic groups and without its SSH password and private key
+	HostConfig HostConfig
+	// StartTime and EndTime are the times the operation started and ended on the host
+	StartTime time.Time
+	EndTime   time.Time
+	Duration  time.Duration
+}
+
+// newErrorResponse returns the CommandResponse of a command that could not be executed because of err
+func newErrorResponse(err error) *CommandResponse {
+	return &CommandResponse{
+		Stdout:  "",
+		Stderr:  "",
+		Error:   err,
+		Code:    -1,
+		Success: false,
+	}
 }
 
 type CommandStatus string
@@ -17,16 +50,111 @@ type CommandStatus string
 const (
 	CommandStatusDone CommandStatus = "done"
 	CommandStatusSkip CommandStatus = "skip"
+	// CommandStatusFailed is the status of a command that returned an error or a stderr output (or a non-zero exit code in a PTY)
+	CommandStatusFailed CommandStatus = "failed"
+	// CommandStatusConditionSkip is the status of a step not run on a host because its When condition is false
+	CommandStatusConditionSkip CommandStatus = "condition_skip"
+	// CommandStatusRolledBack is the status of a command undone by the rollback command of its step
+	CommandStatusRolledBack CommandStatus = "rolled_back"
 )
 
 type MultiCommandResponses struct {
-	Status        CommandStatus
-	Command       string
+	Command string
+	// Handler is empty for the steps of a sequence, and identifies the rollback and handler commands run after them
+	Handler Handler
+	// HostStatuses contains the status of the command for each host
+	HostStatuses  map[string]CommandStatus
 	HostResponses map[string]*CommandResponse
 }
 
 type CommandResponses struct {
 	HostResponses map[string]*CommandResponse
+
+	// hosts contains the hosts of HostResponses in inventory order
+	hosts []string
+}
+
+// Hosts returns the hosts of the responses in inventory order (the order of the HostConfig given to New).
+// Hosts added to HostResponses by the caller are returned last, sorted by name.
+func (r *CommandResponses) Hosts() []string {
+	hosts := make([]string, 0, len(r.HostResponses))
+	for _, host := range r.hosts {
+		if _, ok := r.HostResponses[host]; ok && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+
+	if len(hosts) == len(r.HostResponses) {
+		return hosts
+	}
+
+	otherHosts := make([]string, 0)
+	for host := range r.HostResponses {
+		if !slices.Contains(hosts, host) {
+			otherHosts = append(otherHosts, host)
+		}
+	}
+	sort.Strings(otherHosts)
+
+	return append(hosts, otherHosts...)
+}
+
+// SortedHosts returns the hosts of the responses sorted by name
+func (r *CommandResponses) SortedHosts() []string {
+	hosts := make([]string, 0, len(r.HostResponses))
+	for host := range r.HostResponses {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+// Responses returns the responses in inventory order
+func (r *CommandResponses) Responses() []*CommandResponse {
+	return r.filterResponses(func(commandResponse *CommandResponse) bool {
+		return true
+	})
+}
+
+// Failed returns the responses of the hosts where the operation failed (error, stderr output or non-zero exit code),
+// in inventory order
+func (r *CommandResponses) Failed() []*CommandResponse {
+	return r.filterResponses(func(commandResponse *CommandResponse) bool {
+		return !commandResponse.Success
+	})
+}
+
+// Succeeded returns the responses of the hosts where the operation succeeded, in inventory order
+func (r *CommandResponses) Succeeded() []*CommandResponse {
+	return r.filterResponses(func(commandResponse *CommandResponse) bool {
+		return commandResponse.Success
+	})
+}
+
+// ByGroup returns the responses by group of their host (static and dynamic groups), in inventory order.
+// A response is returned in each group of its host, and not returned if its host has no group.
+func (r *CommandResponses) ByGroup() map[string][]*CommandResponse {
+	groupResponses := make(map[string][]*CommandResponse)
+	for _, commandResponse := range r.Responses() {
+		for _, group := range commandResponse.HostConfig.Groups {
+			groupResponses[group] = append(groupResponses[group], commandResponse)
+		}
+	}
+
+	return groupResponses
+}
+
+// filterResponses returns the responses matching filter in inventory order
+func (r *CommandResponses) filterResponses(filter func(commandResponse *CommandResponse) bool) []*CommandResponse {
+	commandResponses := make([]*CommandResponse, 0, len(r.HostResponses))
+	for _, host := range r.Hosts() {
+		if commandResponse := r.HostResponses[host]; filter(commandResponse) {
+			commandResponses = append(commandResponses, commandResponse)
+		}
+	}
+
+	return commandResponses
 }
 
 func (r *CommandResponses) GetStdoutLines() map[string][]string {
@@ -49,6 +177,39 @@ func (r *CommandResponses) GetStderrLines() map[string][]string {
 	return lineHosts
 }
 
+// DiffGroup contains the hosts sharing the same Diff
+type DiffGroup struct {
+	Diff  string
+	Hosts []string
+}
+
+// GroupDiffs groups the hosts having an identical Diff. Hosts without diff are ignored.
+// Groups are sorted by number of hosts (largest first), and hosts are sorted by name.
+func (r *CommandResponses) GroupDiffs() []DiffGroup {
+	hostsByDiff := make(map[string][]string)
+
+	for host, commandResponse := range r.HostResponses {
+		if commandResponse.Diff != "" {
+			hostsByDiff[commandResponse.Diff] = append(hostsByDiff[commandResponse.Diff], host)
+		}
+	}
+
+	diffGroups := make([]DiffGroup, 0, len(hostsByDiff))
+	for diff, hosts := range hostsByDiff {
+		sort.Strings(hosts)
+		diffGroups = append(diffGroups, DiffGroup{Diff: diff, Hosts: hosts})
+	}
+
+	sort.Slice(diffGroups, func(i, j int) bool {
+		if len(diffGroups[i].Hosts) != len(diffGroups[j].Hosts) {
+			return len(diffGroups[i].Hosts) > len(diffGroups[j].Hosts)
+		}
+		return diffGroups[i].Hosts[0] < diffGroups[j].Hosts[0]
+	})
+
+	return diffGroups
+}
+
 func splitLines(s string) []string {
 	lines := make([]string, 0)
 
@@ -0,0 +1,96 @@
+package parallexe
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestCommandResponsesOrder(t *testing.T) {
+	responses := &CommandResponses{
+		HostResponses: map[string]*CommandResponse{
+			"web2": {Success: true, HostConfig: HostConfig{Host: "web2", Groups: []string{"web"}}},
+			"db1":  {Success: false, HostConfig: HostConfig{Host: "db1", Groups: []string{"db"}}},
+			"web1": {Success: true, HostConfig: HostConfig{Host: "web1", Groups: []string{"web", "front"}}},
+			"misc": {Success: true},
+		},
+		hosts: []string{"web2", "db1", "web1"},
+	}
+
+	if hosts := responses.Hosts(); !slices.Equal(hosts, []string{"web2", "db1", "web1", "misc"}) {
+		t.Errorf("Expected hosts in inventory order, got %v", hosts)
+	}
+	if hosts := responses.SortedHosts(); !slices.Equal(hosts, []string{"db1", "misc", "web1", "web2"}) {
+		t.Errorf("Expected hosts sorted by name, got %v", hosts)
+	}
+
+	if failed := responses.Failed(); len(failed) != 1 || failed[0].HostConfig.Host != "db1" {
+		t.Errorf("Expected db1 to fail, got %v", failed)
+	}
+	if succeeded := responses.Succeeded(); len(succeeded) != 3 || succeeded[0].HostConfig.Host != "web2" {
+		t.Errorf("Expected 3 successful hosts, got %v", succeeded)
+	}
+
+	groups := responses.ByGroup()
+	if len(groups) != 3 || len(groups["web"]) != 2 || groups["web"][1].HostConfig.Host != "web1" || len(groups["front"]) != 1 {
+		t.Errorf("Wrong responses by group, got %v", groups)
+	}
+}
+
+func TestCommandResponseMetadata(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost", Groups: []string{"local"}}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	responses, err := pexe.Exec("sleep 0.1; echo {{ .Host }}", &ExecConfig{Hosts: []string{"127.0.0.1"}, Groups: []string{"local"}, CompileTemplate: true})
+	if err != nil {
+		t.Fatalf("Error during Exec: %v", err)
+	}
+
+	if hosts := responses.Hosts(); !slices.Equal(hosts, []string{"localhost", "127.0.0.1"}) {
+		t.Fatalf("Expected hosts in inventory order, got %v", hosts)
+	}
+
+	response := responses.HostResponses["localhost"]
+	if response.Command != "sleep 0.1; echo localhost" {
+		t.Errorf("Expected the rendered command, got %q", response.Command)
+	}
+	if response.HostConfig.Host != "localhost" || !slices.Equal(response.HostConfig.Groups, []string{"local"}) {
+		t.Errorf("Wrong host configuration, got %+v", response.HostConfig)
+	}
+	if response.Duration < 100*time.Millisecond || !response.EndTime.After(response.StartTime) || response.Attempts != 1 {
+		t.Errorf("Wrong timing, got %v from %v to %v", response.Duration, response.StartTime, response.EndTime)
+	}
+
+	t.Run("Sequence metadata", func(t *testing.T) {
+		multiResponses, err := pexe.MultiExec([]string{"echo step"}, &ExecConfig{Hosts: []string{"localhost"}})
+		if err != nil {
+			t.Fatalf("Error during MultiExec: %v", err)
+		}
+
+		response := multiResponses[0].HostResponses["localhost"]
+		if response.Command != "echo step" || response.HostConfig.Host != "localhost" || response.StartTime.IsZero() {
+			t.Errorf("Expected metadata in sequence responses, got %+v", response)
+		}
+	})
+
+	t.Run("Wrapped command", func(t *testing.T) {
+		responses, _ := pexe.Exec("echo step", &ExecConfig{Hosts: []string{"localhost"}, Become: true})
+
+		if command := responses.HostResponses["localhost"].Command; command != "sudo -n -u root -- sh -c 'echo step'" {
+			t.Errorf("Expected the command run by sudo, got %q", command)
+		}
+	})
+
+	t.Run("Redacted command", func(t *testing.T) {
+		responses, _ := pexe.Exec("echo token", &ExecConfig{Hosts: []string{"localhost"}, Redact: &RedactConfig{Secrets: []string{"token"}}})
+
+		if strings.Contains(responses.HostResponses["localhost"].Command, "token") {
+			t.Errorf("Expected the command to be redacted, got %q", responses.HostResponses["localhost"].Command)
+		}
+	})
+}
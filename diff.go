@@ -0,0 +1,214 @@
+package parallexe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines shown around changes in a unified diff
+const diffContextLines = 3
+
+type diffOperation struct {
+	// Kind is ' ' for an unchanged line, '-' for a removed line and '+' for an added line
+	Kind byte
+	Line string
+	// FromIndex and ToIndex are the positions of the operation in the compared contents
+	FromIndex int
+	ToIndex   int
+}
+
+// unifiedDiff returns the unified diff between from and to contents, or an empty string if they are equal
+func unifiedDiff(fromName string, toName string, from string, to string) string {
+	if from == to {
+		return ""
+	}
+
+	operations := diffLines(splitDiffLines(from), splitDiffLines(to))
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", fromName, toName)
+
+	start := 0
+	for start < len(operations) {
+		// Find the next change
+		for start < len(operations) && operations[start].Kind == ' ' {
+			start++
+		}
+		if start == len(operations) {
+			break
+		}
+
+		// Extend the hunk while changes are close enough to share their context
+		end := start
+		for index := start; index < len(operations); index++ {
+			if operations[index].Kind != ' ' {
+				end = index
+			} else if index-end > 2*diffContextLines {
+				break
+			}
+		}
+
+		hunkStart := start - diffContextLines
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + diffContextLines + 1
+		if hunkEnd > len(operations) {
+			hunkEnd = len(operations)
+		}
+
+		writeDiffHunk(&diff, operations[hunkStart:hunkEnd])
+		start = hunkEnd
+	}
+
+	return diff.String()
+}
+
+// writeDiffHunk writes a hunk header followed by its lines
+func writeDiffHunk(diff *strings.Builder, operations []diffOperation) {
+	fromCount, toCount := 0, 0
+	for _, operation := range operations {
+		if operation.Kind != '+' {
+			fromCount++
+		}
+		if operation.Kind != '-' {
+			toCount++
+		}
+	}
+
+	fmt.Fprintf(diff, "@@ -%s +%s @@\n",
+		diffRange(operations[0].FromIndex, fromCount),
+		diffRange(operations[0].ToIndex, toCount),
+	)
+
+	for _, operation := range operations {
+		diff.WriteByte(operation.Kind)
+		diff.WriteString(operation.Line)
+		if !strings.HasSuffix(operation.Line, "\n") {
+			diff.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// diffRange formats a hunk range. An empty range refers to the line before it.
+func diffRange(index int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", index)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", index+1)
+	}
+
+	return fmt.Sprintf("%d,%d", index+1, count)
+}
+
+// splitDiffLines splits content in lines, keeping the line feeds
+func splitDiffLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// diffMaxSteps is the maximum number of removed and added lines for which diffLines searches the shortest diff.
+// Beyond, the contents are reported as entirely replaced, which bounds the memory used by the search.
+const diffMaxSteps = 1000
+
+// diffLines returns the shortest list of operations transforming from lines into to lines (Myers algorithm),
+// or the removal of all from lines followed by the addition of all to lines if it needs more than diffMaxSteps operations
+func diffLines(from []string, to []string) []diffOperation {
+	maxSteps := len(from) + len(to)
+	// frontier contains for each diagonal k the furthest index reached in from, offset by maxSteps+1
+	offset := maxSteps + 1
+	frontier := make([]int, 2*maxSteps+3)
+	// trace contains, for each step, the frontier of the diagonals -step-1 to step+1 before the step
+	trace := make([][]int, 0)
+	found := false
+
+search:
+	for step := 0; step <= maxSteps && step <= diffMaxSteps; step++ {
+		trace = append(trace, append([]int(nil), frontier[offset-step-1:offset+step+2]...))
+
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && frontier[offset+k-1] < frontier[offset+k+1]) {
+				x = frontier[offset+k+1]
+			} else {
+				x = frontier[offset+k-1] + 1
+			}
+			y := x - k
+
+			for x < len(from) && y < len(to) && from[x] == to[y] {
+				x++
+				y++
+			}
+			frontier[offset+k] = x
+
+			if x >= len(from) && y >= len(to) {
+				found = true
+				break search
+			}
+		}
+	}
+
+	if !found {
+		return replaceLines(from, to)
+	}
+
+	// Backtrack from the end to build the operations
+	operations := make([]diffOperation, 0)
+	x, y := len(from), len(to)
+
+	for step := len(trace) - 1; step >= 0; step-- {
+		// stepFrontier[k+step+1] is the frontier of diagonal k
+		stepFrontier := trace[step]
+		k := x - y
+
+		var previousK int
+		if k == -step || (k != step && stepFrontier[k+step] < stepFrontier[k+step+2]) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+		previousX := stepFrontier[previousK+step+1]
+		previousY := previousX - previousK
+
+		for x > previousX && y > previousY {
+			x--
+			y--
+			operations = append(operations, diffOperation{Kind: ' ', Line: from[x], FromIndex: x, ToIndex: y})
+		}
+
+		if step > 0 {
+			if x == previousX {
+				operations = append(operations, diffOperation{Kind: '+', Line: to[previousY], FromIndex: previousX, ToIndex: previousY})
+			} else {
+				operations = append(operations, diffOperation{Kind: '-', Line: from[previousX], FromIndex: previousX, ToIndex: previousY})
+			}
+		}
+
+		x, y = previousX, previousY
+	}
+
+	// Operations have been built from the end
+	for left, right := 0, len(operations)-1; left < right; left, right = left+1, right-1 {
+		operations[left], operations[right] = operations[right], operations[left]
+	}
+
+	return operations
+}
+
+// replaceLines returns the operations removing all from lines, then adding all to lines
+func replaceLines(from []string, to []string) []diffOperation {
+	operations := make([]diffOperation, 0, len(from)+len(to))
+	for index, line := range from {
+		operations = append(operations, diffOperation{Kind: '-', Line: line, FromIndex: index, ToIndex: 0})
+	}
+	for index, line := range to {
+		operations = append(operations, diffOperation{Kind: '+', Line: line, FromIndex: len(from), ToIndex: index})
+	}
+
+	return operations
+}
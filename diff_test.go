@@ -0,0 +1,52 @@
+package parallexe
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	t.Run("Equal contents", func(t *testing.T) {
+		if diff := unifiedDiff("a", "b", "toto\n", "toto\n"); diff != "" {
+			t.Errorf("Expected empty diff, got %q", diff)
+		}
+	})
+
+	t.Run("Changed line", func(t *testing.T) {
+		diff := unifiedDiff("a", "b", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n")
+		expected := "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n"
+		if diff != expected {
+			t.Errorf("Diff is not correct, got %q", diff)
+		}
+	})
+
+	t.Run("Separate hunks", func(t *testing.T) {
+		diff := unifiedDiff("a", "b", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n", "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n")
+		expected := "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n"
+		if diff != expected {
+			t.Errorf("Diff is not correct, got %q", diff)
+		}
+	})
+
+	t.Run("New file", func(t *testing.T) {
+		diff := unifiedDiff("/dev/null", "b", "", "toto\ntata")
+		expected := "--- /dev/null\n+++ b\n@@ -0,0 +1,2 @@\n+toto\n+tata\n\\ No newline at end of file\n"
+		if diff != expected {
+			t.Errorf("Diff is not correct, got %q", diff)
+		}
+	})
+
+	t.Run("Entirely changed file", func(t *testing.T) {
+		lines := make([]string, 4000)
+		for index := range lines {
+			lines[index] = "line"
+		}
+		from := strings.Join(lines, "\r\n") + "\r\n"
+		to := strings.Join(lines, "\n") + "\n"
+
+		diff := unifiedDiff("a", "b", from, to)
+		if !strings.HasPrefix(diff, "--- a\n+++ b\n@@ -1,4000 +1,4000 @@\n-line\r\n") || strings.Count(diff, "+line\n") != 4000 {
+			t.Errorf("Expected a replacement of all lines, got %d bytes", len(diff))
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package parallexe
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// remoteFile contains a file read on a host
+type remoteFile struct {
+	Exists bool
+	// Attributes contains the user name, uid, group name, gid and octal mode of the file
+	Attributes []string
+	Content    string
+}
+
+// editFileOptions contains the options of editFileOnHosts
+type editFileOptions struct {
+	// Create allows to create the file if it does not exist
+	Create bool
+	// Backup creates a backup of the file before changing it
+	Backup bool
+}
+
+// readRemoteFile reads filePath on a host.
+// If the file can't be read, it returns the failed CommandResponse.
+func readRemoteFile(hostConnection HostConnection, filePath string, execConfig *ExecConfig) (*remoteFile, *CommandResponse) {
+	// Print the file attributes on the first line, then its content
+	// Nothing is printed if the file does not exist
+	quotedPath := ShellQuote(filePath)
+	command := fmt.Sprintf("[ -f %s ] || exit 0; %s && cat -- %s", quotedPath, statCommand(quotedPath), quotedPath)
+
+	commandResponse := executeCommandOnHost(hostConnection, command, execConfig)
+	if commandResponse.Error != nil || commandResponse.Stderr != "" {
+		return nil, commandResponse
+	}
+
+	if commandResponse.Stdout == "" {
+		return &remoteFile{Exists: false}, nil
+	}
+
+	attributes, content, _ := strings.Cut(commandResponse.Stdout, "\n")
+
+	return &remoteFile{
+		Exists:     true,
+		Attributes: strings.Fields(attributes),
+		Content:    content,
+	}, nil
+}
+
+// statCommand returns a command printing the user name, uid, group name, gid and octal mode of quotedPath,
+// following symbolic links. GNU stat (-c) is used when available, otherwise BSD stat (-f) as on macOS and FreeBSD.
+func statCommand(quotedPath string) string {
+	return fmt.Sprintf("if stat -c '%%U' / >/dev/null 2>&1; then stat -L -c '%%U %%u %%G %%g %%a' -- %s; "+
+		"else stat -L -f '%%Su %%u %%Sg %%g %%OMp%%03OLp' -- %s; fi", quotedPath, quotedPath)
+}
+
+// editFileOnHosts reads filePath on each host, edits its content with edit and writes it back if it changed.
+// edit receives the current content of the file (empty if it does not exist) and returns the new content.
+// Responses contain whether the file changed on the host and the diff of the changes.
+// If options.Backup is true and the file changed, the previous file is copied and the backup path is printed in Stdout.
+func editFileOnHosts(hostConnections []HostConnection, filePath string, options editFileOptions, execConfig *ExecConfig, edit func(hostConnection HostConnection, content string, exists bool) (string, error)) (*CommandResponses, error) {
+	execConfig = scriptExecConfig(execConfig)
+
+	return executeOnHosts(hostConnections, execConfig, func(hostConnection HostConnection) *CommandResponse {
+		file, failedResponse := readRemoteFile(hostConnection, filePath, execConfig)
+		if failedResponse != nil {
+			return failedResponse
+		}
+
+		content, err := edit(hostConnection, file.Content, file.Exists)
+		if err != nil {
+			return newErrorResponse(err)
+		}
+
+		if content == file.Content {
+			return &CommandResponse{Success: true}
+		}
+
+		if !file.Exists && !options.Create {
+			return newErrorResponse(fmt.Errorf("file %s does not exist on host %s", filePath, hostConnection.HostConfig.Host))
+		}
+
+		command := writeFileCommand(filePath, content, fileAttributes{}, false)
+		if options.Backup && file.Exists {
+			backupPath := fmt.Sprintf("%s.%s~", filePath, time.Now().Format("2006-01-02@15:04:05"))
+			command = fmt.Sprintf("cp -p -- %s %s && printf '%%s\\n' %s || exit 1\n%s", ShellQuote(filePath), ShellQuote(backupPath), ShellQuote(backupPath), command)
+		}
+
+		fromPath := filePath
+		if !file.Exists {
+			fromPath = "/dev/null"
+		}
+
+		commandResponse := hideFileContent(executeCommandOnHost(hostConnection, command, execConfig), content)
+		if commandResponse.Success {
+			commandResponse.Changed = true
+			commandResponse.Diff = unifiedDiff(fromPath, filePath, file.Content, content)
+		}
+
+		return commandResponse
+	})
+}
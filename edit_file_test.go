@@ -0,0 +1,53 @@
+package parallexe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestReadRemoteFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "parallexe-read")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(filePath, []byte("port=80\n"), 0640); err != nil {
+		t.Fatalf("Error during file creation: %v", err)
+	}
+
+	hostConnection := HostConnection{HostConfig: HostConfig{Host: "localhost"}}
+
+	file, failedResponse := readRemoteFile(hostConnection, filePath, nil)
+	if failedResponse != nil {
+		t.Fatalf("Error during file reading: %+v", failedResponse)
+	}
+	if !file.Exists || file.Content != "port=80\n" || len(file.Attributes) != 5 || file.Attributes[4] != "640" {
+		t.Errorf("Wrong file, got %+v", file)
+	}
+
+	t.Run("BSD stat", func(t *testing.T) {
+		// Fake a BSD stat, which does not support -c
+		binDir := filepath.Join(dir, "bin")
+		if err := os.Mkdir(binDir, 0755); err != nil {
+			t.Fatalf("Error during directory creation: %v", err)
+		}
+		fakeStat := "#!/bin/sh\n[ \"$1 $2\" = '-L -f' ] || { echo 'stat: illegal option -- c' >&2; exit 1; }\n" +
+			"[ \"$3\" = '%Su %u %Sg %g %OMp%03OLp' ] || exit 2\necho 'www 80 www 80 0640'\n"
+		if err := os.WriteFile(filepath.Join(binDir, "stat"), []byte(fakeStat), 0755); err != nil {
+			t.Fatalf("Error during fake stat creation: %v", err)
+		}
+
+		file, failedResponse := readRemoteFile(hostConnection, filePath, &ExecConfig{Env: map[string]string{"PATH": binDir + ":" + os.Getenv("PATH")}})
+		if failedResponse != nil {
+			t.Fatalf("Error during file reading: %+v", failedResponse)
+		}
+		if !slices.Equal(file.Attributes, []string{"www", "80", "www", "80", "0640"}) || file.Content != "port=80\n" {
+			t.Errorf("Wrong file, got %+v", file)
+		}
+	})
+}
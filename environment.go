@@ -0,0 +1,100 @@
+package parallexe
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// envNamePattern matches a valid environment variable name
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateEnvironment checks the environment variables, working directory and shell of execConfig
+func validateEnvironment(execConfig *ExecConfig) error {
+	if execConfig == nil {
+		return nil
+	}
+
+	for name := range execConfig.Env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+	}
+
+	if strings.ContainsRune(execConfig.Dir, 0) || strings.ContainsRune(execConfig.Shell, 0) {
+		return fmt.Errorf("working directory and shell can't contain a NUL byte")
+	}
+
+	return nil
+}
+
+// shellCommand returns cmd run by execConfig.Shell in execConfig.Dir.
+// If envPrefix is true, execConfig.Env is set with an env prefix, for when it can't be set on the session.
+func shellCommand(cmd string, execConfig *ExecConfig, envPrefix bool) string {
+	if execConfig == nil {
+		return cmd
+	}
+
+	shell := execConfig.Shell
+	if shell == "" && envPrefix && len(execConfig.Env) > 0 {
+		// env only applies to a single command, cmd may contain several ones
+		shell = "sh"
+	}
+
+	command := cmd
+	if shell != "" {
+		command = ShellJoin(shell, "-c", cmd)
+	}
+
+	if envPrefix && len(execConfig.Env) > 0 {
+		command = fmt.Sprintf("env %s %s", ShellJoin(envList(execConfig.Env)...), command)
+	}
+
+	if execConfig.Dir != "" {
+		command = fmt.Sprintf("cd %s && %s", ShellQuote(execConfig.Dir), command)
+	}
+
+	return command
+}
+
+// setSessionEnv sets the environment variables on a SSH session.
+// It returns false if the server rejects one of them (see AcceptEnv in sshd_config).
+func setSessionEnv(session *ssh.Session, env map[string]string) bool {
+	for _, variable := range sortedKeys(env) {
+		if err := session.Setenv(variable, env[variable]); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// localEnv returns the environment of a local command: the current environment with env added
+func localEnv(env map[string]string) []string {
+	return append(os.Environ(), envList(env)...)
+}
+
+// envList returns env as a sorted list of NAME=value
+func envList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for _, name := range sortedKeys(env) {
+		list = append(list, fmt.Sprintf("%s=%s", name, env[name]))
+	}
+
+	return list
+}
+
+// sortedKeys returns the keys of a map sorted
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
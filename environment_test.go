@@ -0,0 +1,112 @@
+package parallexe
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestShellCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		execConfig *ExecConfig
+		envPrefix  bool
+		expected   string
+	}{
+		{"No config", nil, false, "echo $A"},
+		{"Shell", &ExecConfig{Shell: "bash"}, false, "bash -c 'echo $A'"},
+		{"Directory", &ExecConfig{Dir: "/my dir"}, false, "cd '/my dir' && echo $A"},
+		{"Env on session", &ExecConfig{Env: map[string]string{"A": "1"}}, false, "echo $A"},
+		{"Env prefix", &ExecConfig{Env: map[string]string{"B": "it's", "A": "1"}}, true, "env 'A=1' 'B=it'\\''s' sh -c 'echo $A'"},
+		{"All", &ExecConfig{Env: map[string]string{"A": "1"}, Dir: "/tmp", Shell: "bash"}, true, "cd /tmp && env 'A=1' bash -c 'echo $A'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := shellCommand("echo $A", test.execConfig, test.envPrefix)
+			if result != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestValidateEnvironment(t *testing.T) {
+	if err := validateEnvironment(&ExecConfig{Env: map[string]string{"MY_VAR1": "$(id)"}}); err != nil {
+		t.Errorf("Expected environment to be valid, got %v", err)
+	}
+
+	for _, name := range []string{"1VAR", "MY-VAR", "A=B", "A; id", ""} {
+		if err := validateEnvironment(&ExecConfig{Env: map[string]string{name: "value"}}); err == nil {
+			t.Errorf("Expected %q to be invalid", name)
+		}
+	}
+}
+
+func TestExecEnvironment(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	dir, err := os.MkdirTemp("", "test dir")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+
+	execConfig := &ExecConfig{
+		Env: map[string]string{"GREETING": "it's me"},
+		Dir: dir,
+	}
+
+	t.Run("Env and directory", func(t *testing.T) {
+		response, err := pexe.Exec("echo \"$GREETING\"; pwd", execConfig)
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		expected := "it's me\n" + dir + "\n"
+		if response.HostResponses["localhost"].Stdout != expected {
+			t.Errorf("Expected %q, got %q", expected, response.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("Shell", func(t *testing.T) {
+		if _, err := exec.LookPath("python3"); err != nil {
+			t.Skip("python3 is not installed")
+		}
+
+		response, err := pexe.Exec("import os; print(os.environ['GREETING'])", &ExecConfig{Env: execConfig.Env, Shell: "python3"})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+		if response.HostResponses["localhost"].Stdout != "it's me\n" {
+			t.Errorf("Expected %q, got %q", "it's me\n", response.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("Env prefix with become", func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("su without password requires to run as root")
+		}
+
+		response, err := pexe.Exec("echo \"$GREETING\"; pwd", &ExecConfig{
+			Env:          execConfig.Env,
+			Dir:          execConfig.Dir,
+			Become:       true,
+			BecomeMethod: BecomeSu,
+		})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		expected := "it's me\n" + dir + "\n"
+		if response.HostResponses["localhost"].Stdout != expected {
+			t.Errorf("Expected %q, got %q", expected, response.HostResponses["localhost"].Stdout)
+		}
+	})
+}
@@ -4,115 +4,231 @@ import (
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/exp/slices"
+	"io"
 	"os/exec"
 	"strings"
 	"sync"
+	"time"
 )
 
 type ExecConfig struct {
 	Hosts  []string
 	Groups []string
+	// FactsFilter selects, among the hosts filtered by Hosts and Groups, the ones whose facts it returns true for.
+	// Hosts whose facts have not been gathered with GatherFacts are excluded.
+	FactsFilter func(facts *Facts) bool
+	// Become runs the commands as BecomeUser with BecomeMethod (privilege escalation).
+	// Files sent with Send or edited with LineInFile and BlockInFile are then written as BecomeUser.
+	Become bool
+	// BecomeMethod is the privilege escalation method: BecomeSudo (default), BecomeSu or BecomeDoas
+	BecomeMethod BecomeMethod
+	// BecomeUser is the user the commands are run as. Default is root.
+	BecomeUser string
+	// BecomePassword is the password asked by BecomeMethod. If empty, the commands fail instead of waiting for a password.
+	// It is written on stdin when sudo prompts for it, and typed when it is prompted in a PTY for su and doas (remote hosts only).
+	// It is not sent when no password is asked (e.g. NOPASSWD sudo rule): the command only gets its input.
+	// It is redacted from the responses.
+	BecomePassword string
+	// Env contains the environment variables of the commands.
+	// On remote hosts, they are set on the SSH session, or with an env prefix if the server rejects them.
+	Env map[string]string
+	// Dir is the working directory of the commands
+	Dir string
+	// Shell is the shell or interpreter running the commands with its -c option (e.g. bash, sh, python3).
+	// If empty, commands are run by sh on localhost and by the login shell of the user on remote hosts.
+	// It only applies to the commands of Exec, MultiExec and sequences: the scripts of Send, LineInFile, BlockInFile,
+	// Script and GatherFacts are always run by sh.
+	Shell string
+	// Stdin is the input of the commands run by Exec and MultiExec. It is read once and sent to each host and each command.
+	Stdin io.Reader
+	// HostStdin contains the input of the commands per host, overriding Stdin
+	HostStdin map[string]io.Reader
+	// Pty allocates a pseudo-terminal for the commands run by Exec and MultiExec (remote hosts only).
+	// Stdout and stderr are then merged in CommandResponse.Stdout, so a non-zero exit code sets CommandResponse.Error.
+	Pty bool
+	// PtyTerm is the terminal type of the PTY. Default is xterm.
+	PtyTerm string
+	// PtyWidth and PtyHeight are the size of the PTY in characters. Default is 80x40.
+	PtyWidth  int
+	PtyHeight int
+	// CompileTemplate renders the commands of Exec and MultiExec as go templates for each host, with ExecVariables.
+	// Templates also get the host name in .Host and its groups in .Groups, and can quote values with {{ quote .var }}.
+	CompileTemplate bool
+	// Strategy defines how MultiExec continues when a command fails on a host. Default is StrategyAbortAll.
+	Strategy Strategy
+	// Retry defines how the commands of Exec and MultiExec are retried when they fail, or re-run until a condition is met
+	Retry *RetryConfig
+	// ExecVariables contains the variables of the command templates when CompileTemplate is true
+	ExecVariables *ExecVariables
+	// Redact defines the sensitive values replaced in the responses of all operations
+	Redact *RedactConfig
+
+	input *commandInput
 }
 
+// Strategy defines how the commands of MultiExec continue when a command fails on a host
+type Strategy string
+
+const (
+	// StrategyAbortAll stops all hosts after the command that failed
+	StrategyAbortAll Strategy = "abort_all"
+	// StrategyContinue stops only the failed hosts, the others run all the commands
+	StrategyContinue Strategy = "continue"
+	// StrategyFree runs the commands on each host independently, without waiting for the other hosts
+	StrategyFree Strategy = "free"
+)
+
 // Exec executes a command on a list of hosts
+// If execConfig.CompileTemplate is true, the command is rendered for each host. A host whose command can't be rendered
+// gets the rendering error in its CommandResponse.
+// The command is retried as defined by execConfig.Retry.
 func (p *Parallexe) Exec(command string, execConfig *ExecConfig) (*CommandResponses, error) {
+	hostCommand, err := commandTemplate(command, execConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.execHostCommand(hostCommand, execConfig)
+}
+
+// execHostCommand executes on each host the command built for it by hostCommand, as Exec
+func (p *Parallexe) execHostCommand(hostCommand func(hostConnection HostConnection) (string, error), execConfig *ExecConfig) (*CommandResponses, error) {
+	var retry *RetryConfig
+	if execConfig != nil {
+		retry = execConfig.Retry
+	}
+
+	rules, err := compileRetryRules(retry)
+	if err != nil {
+		return nil, err
+	}
+
 	// White list HostSession to execute only on desired hosts
 	filteredHosts := getFilteredHosts(p.HostConnections, execConfig)
 
+	execConfig, err = prepareCommandInput(execConfig, filteredHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return executeOnHosts(filteredHosts, execConfig, func(hostConnection HostConnection) *CommandResponse {
+		renderedCommand, err := hostCommand(hostConnection)
+		if err != nil {
+			return newErrorResponse(err)
+		}
+
+		return rules.execute(func() *CommandResponse {
+			return executeCommandOnHost(hostConnection, renderedCommand, execConfig)
+		})
+	})
+}
+
+// executeOnHosts calls execute for each host in parallel and collects the responses by host,
+// redacted as defined by execConfig.Redact and the secrets rendered for each host.
+// It returns an error listing the hosts where the response contains an error or a stderr output.
+func executeOnHosts(hostConnections []HostConnection, execConfig *ExecConfig, execute func(hostConnection HostConnection) *CommandResponse) (*CommandResponses, error) {
 	var wg sync.WaitGroup
-	wg.Add(len(filteredHosts))
+	wg.Add(len(hostConnections))
 
+	var m sync.Mutex
 	commandResponses := make(map[string]*CommandResponse, 0)
-	errorHosts := make([]string, 0)
 
-	for _, host := range filteredHosts {
-		loopHost := host
+	hosts := make([]string, 0, len(hostConnections))
+	for _, host := range hostConnections {
+		hosts = append(hosts, host.HostConfig.Host)
+
+		loopHost := host.withSecretScope()
 		go func() {
-			commandResponse := executeCommandOnHost(loopHost, command, &wg)
+			defer wg.Done()
+
+			commandResponse := executeWithMetadata(loopHost, func() *CommandResponse {
+				return execute(loopHost)
+			})
+			commandResponse = loopHost.redactResponse(commandResponse, redactConfig(execConfig))
+
+			m.Lock()
+			defer m.Unlock()
 
 			commandResponses[loopHost.HostConfig.Host] = commandResponse
-			if commandResponse.Error != nil || commandResponse.Stderr != "" {
-				errorHosts = append(errorHosts, loopHost.HostConfig.Host)
-			}
 		}()
 	}
 
 	wg.Wait()
 
+	// Error hosts are listed in the order of hostConnections, not in completion order
+	errorHosts := make([]string, 0)
+	for _, host := range hosts {
+		if commandResponse := commandResponses[host]; commandResponse.Error != nil || commandResponse.Stderr != "" {
+			errorHosts = append(errorHosts, host)
+		}
+	}
+
 	var commandError error
 	if len(errorHosts) > 0 {
 		commandError = fmt.Errorf("error on hosts: %v", errorHosts)
 	}
 
-	return &CommandResponses{HostResponses: commandResponses}, commandError
+	return &CommandResponses{HostResponses: commandResponses, hosts: hosts}, commandError
 }
 
-// MultiExec executes a list of commands on a list of hosts.
-// It returns a list of MultiCommandResponses, each one containing the command and the responses for each host.
-// If a command fails on a host, the next commands will not be executed on any host.
-// Commands not executed on any host will have a status CommandStatusSkip.
-func (p *Parallexe) MultiExec(commands []string, execConfig *ExecConfig) ([]*MultiCommandResponses, error) {
-	// White list HostSession to execute only on desired hosts
-	filteredHosts := getFilteredHosts(p.HostConnections, execConfig)
-
-	multiCommandResponses := make([]*MultiCommandResponses, 0)
+// executeWithMetadata calls execute on a host and sets the host configuration and the times of the returned response
+func executeWithMetadata(hostConnection HostConnection, execute func() *CommandResponse) *CommandResponse {
+	startTime := time.Now()
+	commandResponse := execute()
+	endTime := time.Now()
 
-	for _, command := range commands {
-		multiCommandResponses = append(multiCommandResponses, &MultiCommandResponses{
-			Command:       command,
-			Status:        CommandStatusSkip,
-			HostResponses: make(map[string]*CommandResponse),
-		})
+	hostConfig := hostConnection.HostConfig
+	hostConfig.Groups = hostConnection.Groups()
+	if hostConfig.SshConfig != nil {
+		hostConfig.SshConfig = &SshConfig{User: hostConfig.SshConfig.User, PrivateKeyPath: hostConfig.SshConfig.PrivateKeyPath}
 	}
 
-	var m sync.Mutex
-	errorHosts := make([]string, 0)
-
-	for index, command := range commands {
-		loopCommandIndex := index
-		loopCommand := command
+	commandResponse.HostConfig = hostConfig
+	commandResponse.StartTime = startTime
+	commandResponse.EndTime = endTime
+	commandResponse.Duration = endTime.Sub(startTime)
 
-		var wg sync.WaitGroup
-		wg.Add(len(filteredHosts))
-
-		for _, host := range filteredHosts {
-			loopHost := host
-			go func() {
-
-				commandResponse := executeCommandOnHost(loopHost, loopCommand, &wg)
-
-				m.Lock()
-				multiCommandResponses[loopCommandIndex].HostResponses[loopHost.HostConfig.Host] = commandResponse
-				multiCommandResponses[loopCommandIndex].Status = CommandStatusDone
-				m.Unlock()
+	return commandResponse
+}
 
-				if commandResponse.Error != nil || commandResponse.Stderr != "" {
-					errorHosts = append(errorHosts, loopHost.HostConfig.Host)
-				}
-			}()
+// MultiExec executes a list of commands on a list of hosts.
+// It returns a list of MultiCommandResponses, each one containing the command, the responses and the status for each host.
+// What happens when a command fails on a host depends on execConfig.Strategy:
+// with StrategyAbortAll (default), the next commands will not be executed on any host,
+// with StrategyContinue, only the failed host stops while the others finish the list,
+// with StrategyFree, each host runs through the list at its own pace and stops at its first failure.
+// Commands not executed on a host will have a status CommandStatusSkip for this host.
+// If execConfig.CompileTemplate is true, the commands are rendered for each host as in Exec.
+func (p *Parallexe) MultiExec(commands []string, execConfig *ExecConfig) ([]*MultiCommandResponses, error) {
+	steps := make([]Step, len(commands))
+	for index, command := range commands {
+		steps[index] = Step{Command: command}
+	}
 
-			if len(errorHosts) > 0 {
-				break
-			}
-		}
+	return p.RunSequence(&Sequence{Steps: steps}, execConfig)
+}
 
-		if len(errorHosts) > 0 {
-			break
-		}
+// getFilteredHosts returns a list of HostSession filtered by ExecConfig
+func getFilteredHosts(hostConnections []HostConnection, execConfig *ExecConfig) []HostConnection {
+	filteredHosts := getSelectedHosts(hostConnections, execConfig)
 
-		wg.Wait()
+	if execConfig == nil || execConfig.FactsFilter == nil {
+		return filteredHosts
 	}
 
-	var commandError error
-	if len(errorHosts) > 0 {
-		commandError = fmt.Errorf("error on hosts: %v", errorHosts)
+	factsHosts := make([]HostConnection, 0, len(filteredHosts))
+	for _, hostConnection := range filteredHosts {
+		if facts := hostConnection.Facts(); facts != nil && execConfig.FactsFilter(facts) {
+			factsHosts = append(factsHosts, hostConnection)
+		}
 	}
 
-	return multiCommandResponses, commandError
+	return factsHosts
 }
 
-// getFilteredHosts returns a list of HostSession filtered by ExecConfig
-func getFilteredHosts(hostConnections []HostConnection, execConfig *ExecConfig) []HostConnection {
+// getSelectedHosts returns a list of HostSession filtered by the Hosts and Groups of ExecConfig.
+// Hosts are kept in the order of hostConnections, and selected once even if they match both Hosts and Groups.
+func getSelectedHosts(hostConnections []HostConnection, execConfig *ExecConfig) []HostConnection {
 	filteredHosts := make([]HostConnection, 0)
 
 	if execConfig == nil || (len(execConfig.Hosts) == 0 && len(execConfig.Groups) == 0) {
@@ -120,21 +236,16 @@ func getFilteredHosts(hostConnections []HostConnection, execConfig *ExecConfig)
 			filteredHosts = append(filteredHosts, host)
 		}
 	} else {
-		if len(execConfig.Hosts) > 0 {
-			for _, hostConnection := range hostConnections {
-				if slices.Contains(execConfig.Hosts, hostConnection.HostConfig.Host) {
-					filteredHosts = append(filteredHosts, hostConnection)
-				}
+		for _, hostConnection := range hostConnections {
+			if slices.Contains(execConfig.Hosts, hostConnection.HostConfig.Host) {
+				filteredHosts = append(filteredHosts, hostConnection)
+				continue
 			}
-		}
 
-		if len(execConfig.Groups) > 0 {
-			for _, hostConnection := range hostConnections {
-				for _, group := range hostConnection.HostConfig.Groups {
-					if slices.Contains(execConfig.Groups, group) {
-						filteredHosts = append(filteredHosts, hostConnection)
-						break
-					}
+			for _, group := range hostConnection.Groups() {
+				if slices.Contains(execConfig.Groups, group) {
+					filteredHosts = append(filteredHosts, hostConnection)
+					break
 				}
 			}
 		}
@@ -143,85 +254,177 @@ func getFilteredHosts(hostConnections []HostConnection, execConfig *ExecConfig)
 	return filteredHosts
 }
 
-// remoteExecute executes a command on a remote host.
+// executeCommandOnHost executes a command on a remote host.
 // If hostSession.Client is nil, run command locally.
-func executeCommandOnHost(hostSession HostConnection, cmd string, wg *sync.WaitGroup) *CommandResponse {
-	defer wg.Done()
+// The command is run with the environment, working directory and shell of execConfig,
+// as another user if execConfig.Become is true.
+// The returned CommandResponse.Command is the command run, wrapped by the shell and become commands,
+// or cmd if it could not be wrapped.
+func executeCommandOnHost(hostSession HostConnection, cmd string, execConfig *ExecConfig) *CommandResponse {
+	var commandResponse *CommandResponse
+	if err := validateEnvironment(execConfig); err != nil {
+		commandResponse = newErrorResponse(err)
+	} else if hostSession.Client == nil {
+		commandResponse = localExecute(hostSession, cmd, execConfig)
+	} else {
+		// Execute command on remote host
+		commandResponse = remoteExecute(hostSession, cmd, execConfig)
+	}
 
-	if hostSession.Client == nil {
-		return localExecute(cmd)
+	if commandResponse.Command == "" {
+		commandResponse.Command = cmd
 	}
 
-	// Execute command on remote host
-	return remoteExecute(hostSession, cmd)
+	return commandResponse
 }
 
 // remoteExecute executes a command on a remote host
-func remoteExecute(hostSession HostConnection, cmd string) *CommandResponse {
+// Environment variables are set on the session. If the server rejects them, or when the command is run as another user,
+// they are set with an env prefix.
+// The become password is written on stdin for sudo, or typed in a PTY when it is prompted for su and doas,
+// followed by the command input.
+func remoteExecute(hostSession HostConnection, cmd string, execConfig *ExecConfig) (commandResponse *CommandResponse) {
 	var stdout strings.Builder
 	var stderr strings.Builder
 
 	session, err := hostSession.Client.NewSession()
 	if err != nil {
-		return &CommandResponse{
-			Stdout:  "",
-			Stderr:  "",
-			Error:   fmt.Errorf("can't open SSH connection: %v", err),
-			Code:    -1,
-			Success: false,
-		}
+		return newErrorResponse(fmt.Errorf("can't open SSH connection: %v", err))
 	}
 	defer session.Close()
 
+	envPrefix := false
+	if execConfig != nil {
+		envPrefix = execConfig.Become || !setSessionEnv(session, execConfig.Env)
+	}
+
+	cmd, err = becomeCommand(shellCommand(cmd, execConfig, envPrefix), execConfig)
+	if err != nil {
+		return newErrorResponse(err)
+	}
+	defer func() {
+		commandResponse.Command = cmd
+	}()
+
 	session.Stdout = &stdout
 	session.Stderr = &stderr
 
+	if ptyRequested(execConfig) {
+		term, width, height := ptySize(execConfig)
+		err = session.RequestPty(term, height, width, ssh.TerminalModes{ssh.ECHO: 0, ssh.ONLCR: 0})
+		if err != nil {
+			return newErrorResponse(fmt.Errorf("can't request PTY: %v", err))
+		}
+	}
+
+	var promptWriter *passwordPromptWriter
+	if becomePassword(execConfig) {
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return newErrorResponse(fmt.Errorf("can't open stdin: %v", err))
+		}
+
+		promptWriter = newPasswordPromptWriter(execConfig, &stdout, &stderr, stdin, hostStdin(execConfig, hostSession.HostConfig.Host))
+		if becomePasswordOnStdin(execConfig) {
+			session.Stderr = promptWriter
+		} else {
+			session.Stdout = promptWriter
+		}
+	} else if stdin := hostStdin(execConfig, hostSession.HostConfig.Host); stdin != nil {
+		session.Stdin = stdin
+	}
+
 	var code int
 	err = session.Run(cmd)
 
+	if promptWriter != nil {
+		promptWriter.Flush()
+	}
+
 	if exitErr, ok := err.(*ssh.ExitError); ok {
 		code = exitErr.ExitStatus()
 	} else if err != nil {
-		return &CommandResponse{
-			Stdout:  "",
-			Stderr:  "",
-			Error:   err,
-			Code:    -1,
-			Success: false,
-		}
+		return newErrorResponse(err)
 	}
 
 	return &CommandResponse{
 		Stdout:  stdout.String(),
 		Stderr:  stderr.String(),
-		Error:   nil,
+		Error:   ptyExitError(code, execConfig),
 		Code:    code,
 		Success: err == nil && stderr.String() == "",
 	}
 }
 
 // localExecute executes a command locally
-func localExecute(cmd string) *CommandResponse {
+// The command is run by execConfig.Shell (sh by default) in execConfig.Dir, with execConfig.Env added to the current environment.
+// When the command is run as another user, they are set in the command as for remote hosts.
+// The become password is written on stdin when sudo prompts for it, followed by the command input.
+// su and doas with a password and PTY are not supported.
+func localExecute(hostSession HostConnection, cmd string, execConfig *ExecConfig) (commandResponse *CommandResponse) {
 	var stdout strings.Builder
 	var stderr strings.Builder
 
-	command := exec.Command("sh", "-c", cmd)
+	if becomePasswordOnPrompt(execConfig) {
+		return newErrorResponse(fmt.Errorf("become method %s with a password is not supported on localhost", execConfig.becomeMethod()))
+	}
+
+	if ptyRequested(execConfig) {
+		return newErrorResponse(fmt.Errorf("PTY is not supported on localhost"))
+	}
+
+	shell := "sh"
+	var command *exec.Cmd
+
+	if execConfig != nil && execConfig.Become {
+		becomeCmd, err := becomeCommand(shellCommand(cmd, execConfig, true), execConfig)
+		if err != nil {
+			return newErrorResponse(err)
+		}
+
+		command = exec.Command(shell, "-c", becomeCmd)
+		defer func() {
+			commandResponse.Command = becomeCmd
+		}()
+	} else {
+		if execConfig != nil && execConfig.Shell != "" {
+			shell = execConfig.Shell
+		}
+
+		command = exec.Command(shell, "-c", cmd)
+		if execConfig != nil {
+			command.Dir = execConfig.Dir
+			command.Env = localEnv(execConfig.Env)
+		}
+	}
+
 	command.Stdout = &stdout
 	command.Stderr = &stderr
 
+	var promptWriter *passwordPromptWriter
+	if becomePasswordOnStdin(execConfig) {
+		stdin, err := command.StdinPipe()
+		if err != nil {
+			return newErrorResponse(fmt.Errorf("can't open stdin: %v", err))
+		}
+
+		promptWriter = newPasswordPromptWriter(execConfig, &stdout, &stderr, stdin, hostStdin(execConfig, hostSession.HostConfig.Host))
+		command.Stderr = promptWriter
+	} else if stdin := hostStdin(execConfig, hostSession.HostConfig.Host); stdin != nil {
+		command.Stdin = stdin
+	}
+
 	var code int
 	err := command.Run()
 
+	if promptWriter != nil {
+		promptWriter.Flush()
+	}
+
 	if exitErr, ok := err.(*exec.ExitError); ok {
 		code = exitErr.ExitCode()
 	} else if err != nil {
-		return &CommandResponse{
-			Stdout:  "",
-			Stderr:  "",
-			Error:   err,
-			Code:    -1,
-			Success: false,
-		}
+		return newErrorResponse(err)
 	}
 
 	return &CommandResponse{
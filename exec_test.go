@@ -159,6 +159,20 @@ func TestExec(t *testing.T) {
 	})
 }
 
+func TestExecErrorHosts(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	// localhost fails last, but is listed first as in the inventory
+	_, err = pexe.Exec(`{{ if eq .Host "localhost" }}sleep 0.2; {{ end }}echo failed >&2`, &ExecConfig{CompileTemplate: true})
+	if err == nil || err.Error() != "error on hosts: [localhost 127.0.0.1]" {
+		t.Errorf("Expected error hosts in inventory order, got %v", err)
+	}
+}
+
 func TestMultiExec(t *testing.T) {
 	pexe, err := New([]HostConfig{{Host: "localhost"}})
 	if err != nil {
@@ -186,3 +200,156 @@ func TestMultiExec(t *testing.T) {
 		}
 	})
 }
+
+func TestExecTemplate(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost", Groups: []string{"web"}}, {Host: "127.0.0.1", Groups: []string{"db"}}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	execVariables := &ExecVariables{
+		Variables: KeyValueVariable{"service": "default"},
+		GroupVariables: map[string]KeyValueVariable{
+			"web": {"service": "nginx"},
+			"db":  {"service": "it's postgres"},
+		},
+	}
+
+	t.Run("Command rendered per host", func(t *testing.T) {
+		responses, err := pexe.Exec("echo {{ quote .service }} {{ .Host }} {{ index .Groups 0 }}", &ExecConfig{
+			CompileTemplate: true,
+			ExecVariables:   execVariables,
+		})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "nginx localhost web\n" {
+			t.Errorf("Wrong output on localhost, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+		if responses.HostResponses["127.0.0.1"].Stdout != "it's postgres 127.0.0.1 db\n" {
+			t.Errorf("Wrong output on 127.0.0.1, got %q", responses.HostResponses["127.0.0.1"].Stdout)
+		}
+	})
+
+	t.Run("Command not rendered without CompileTemplate", func(t *testing.T) {
+		responses, err := pexe.Exec("echo '{{ .Host }}'", &ExecConfig{Hosts: []string{"localhost"}})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "{{ .Host }}\n" {
+			t.Errorf("Expected the command as is, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("MultiExec commands rendered per host", func(t *testing.T) {
+		responses, err := pexe.MultiExec([]string{"echo {{ .service }}", "echo {{ .Host }}"}, &ExecConfig{
+			Groups:          []string{"web"},
+			CompileTemplate: true,
+			ExecVariables:   execVariables,
+		})
+		if err != nil {
+			t.Fatalf("Error during MultiExec: %v", err)
+		}
+
+		if responses[0].HostResponses["localhost"].Stdout != "nginx\n" {
+			t.Errorf("Wrong output of first command, got %q", responses[0].HostResponses["localhost"].Stdout)
+		}
+		if responses[1].HostResponses["localhost"].Stdout != "localhost\n" {
+			t.Errorf("Wrong output of second command, got %q", responses[1].HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("Parse error", func(t *testing.T) {
+		_, err := pexe.Exec("echo {{ .service", &ExecConfig{CompileTemplate: true})
+		if err == nil {
+			t.Fatalf("Expected a parse error")
+		}
+	})
+
+	t.Run("Render error on a host", func(t *testing.T) {
+		responses, err := pexe.Exec("echo {{ index .service 1 }}", &ExecConfig{
+			CompileTemplate: true,
+			ExecVariables: &ExecVariables{
+				HostVariables: map[string]KeyValueVariable{
+					"localhost": {"service": []string{"a", "b"}},
+					"127.0.0.1": {"service": []string{"a"}},
+				},
+			},
+		})
+		if err == nil {
+			t.Fatalf("Expected a render error")
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "b\n" {
+			t.Errorf("Expected command to run on localhost, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+		if responses.HostResponses["127.0.0.1"].Error == nil {
+			t.Errorf("Expected a render error on 127.0.0.1")
+		}
+	})
+}
+
+func TestMultiExecStrategies(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	// The second command fails on 127.0.0.1 only
+	commands := []string{
+		"echo 1",
+		`{{ if eq .Host "127.0.0.1" }}echo failed >&2{{ else }}echo 2{{ end }}`,
+		"echo 3",
+	}
+
+	tests := []struct {
+		strategy Strategy
+		expected map[string][]CommandStatus
+	}{
+		{"", map[string][]CommandStatus{
+			"localhost": {CommandStatusDone, CommandStatusDone, CommandStatusSkip},
+			"127.0.0.1": {CommandStatusDone, CommandStatusFailed, CommandStatusSkip},
+		}},
+		{StrategyContinue, map[string][]CommandStatus{
+			"localhost": {CommandStatusDone, CommandStatusDone, CommandStatusDone},
+			"127.0.0.1": {CommandStatusDone, CommandStatusFailed, CommandStatusSkip},
+		}},
+		{StrategyFree, map[string][]CommandStatus{
+			"localhost": {CommandStatusDone, CommandStatusDone, CommandStatusDone},
+			"127.0.0.1": {CommandStatusDone, CommandStatusFailed, CommandStatusSkip},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("strategy %q", test.strategy), func(t *testing.T) {
+			responses, err := pexe.MultiExec(commands, &ExecConfig{CompileTemplate: true, Strategy: test.strategy})
+			if err == nil || err.Error() != "error on hosts: [127.0.0.1]" {
+				t.Fatalf("Expected an error on 127.0.0.1, got %v", err)
+			}
+
+			for host, statuses := range test.expected {
+				for index, status := range statuses {
+					if responses[index].HostStatuses[host] != status {
+						t.Errorf("Expected status %s for command %d on %s, got %s", status, index, host, responses[index].HostStatuses[host])
+					}
+
+					_, executed := responses[index].HostResponses[host]
+					if executed != (status != CommandStatusSkip) {
+						t.Errorf("Unexpected response for command %d on %s", index, host)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("Unknown strategy", func(t *testing.T) {
+		_, err := pexe.MultiExec(commands, &ExecConfig{Strategy: "random"})
+		if err == nil {
+			t.Fatalf("Expected an error for an unknown strategy")
+		}
+	})
+}
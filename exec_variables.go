@@ -1,42 +1,289 @@
 package parallexe
 
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
 type KeyValueVariable map[string]interface{}
 
 type ExecVariables struct {
+	// Defaults are the inventory defaults of all hosts, overridden by any other variable (including Host, Groups and Facts)
+	Defaults KeyValueVariable
 	// Variables will be injected for all hosts
 	Variables KeyValueVariable
 	// GroupVariables will be injected for corresponding group and will override Variables
 	GroupVariables map[string]KeyValueVariable
 	// HostVariables will be injected in template and will override GroupVariables
 	HostVariables map[string]KeyValueVariable
+	// RegisteredVariables contains results registered per host, e.g. by a previous sequence (see Step.Register).
+	// They will override HostVariables, and are overridden by the results registered by the steps of the running sequence.
+	RegisteredVariables map[string]KeyValueVariable
+	// ExtraVariables override all other variables, as the extra-vars of the command line
+	ExtraVariables KeyValueVariable
+	// Secrets decrypts the SecretRef values of the variables when a template is rendered
+	Secrets *SecretStore
+	// Sensitive contains the names of the variables whose values are redacted from the responses of the operation
+	// they are rendered for, as the SecretRef values
+	Sensitive []string
+
+	// GroupPriority defines the order in which the GroupVariables of a host in several groups are merged:
+	// the variables of a group override the ones of the groups with a lower priority.
+	// Groups without priority have priority 0. Groups with the same priority are merged in the order of the host groups.
+	GroupPriority map[string]int
+	// DeepMerge merges nested maps instead of replacing them: only the keys also defined by the overriding layer are replaced
+	DeepMerge bool
+	// AppendLists appends the lists of the overriding layers to the overridden ones when DeepMerge is true,
+	// instead of replacing them
+	AppendLists bool
+
+	// registered contains the results registered by the steps of the running sequence
+	registered *registeredResults
+}
+
+// RegisteredResult is the result of a step registered as a variable
+type RegisteredResult struct {
+	Stdout  string
+	Stderr  string
+	Code    int
+	Success bool
+	// Lines contains the non-empty lines of Stdout
+	Lines []string
+	// Json contains Stdout parsed as JSON, or nil if it is not valid JSON
+	Json interface{}
+}
+
+// newRegisteredResult returns the result of a CommandResponse to register
+func newRegisteredResult(commandResponse *CommandResponse) *RegisteredResult {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(commandResponse.Stdout), &parsed); err != nil {
+		parsed = nil
+	}
+
+	return &RegisteredResult{
+		Stdout:  commandResponse.Stdout,
+		Stderr:  commandResponse.Stderr,
+		Code:    commandResponse.Code,
+		Success: commandResponse.Success,
+		Lines:   splitLines(commandResponse.Stdout),
+		Json:    parsed,
+	}
+}
+
+// registeredResults contains the results registered per host by the steps of a sequence or the tasks of a playbook
+type registeredResults struct {
+	m         sync.Mutex
+	variables map[string]KeyValueVariable
+}
+
+func newRegisteredResults() *registeredResults {
+	return &registeredResults{variables: make(map[string]KeyValueVariable)}
+}
+
+// register sets a registered variable of a host
+func (r *registeredResults) register(host string, name string, value interface{}) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.variables[host] == nil {
+		r.variables[host] = make(KeyValueVariable)
+	}
+	r.variables[host][name] = value
+}
+
+// export adds the registered variables to execVariables.RegisteredVariables
+func (r *registeredResults) export(execVariables *ExecVariables) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for host, variables := range r.variables {
+		if execVariables.RegisteredVariables == nil {
+			execVariables.RegisteredVariables = make(map[string]KeyValueVariable)
+		}
+		if execVariables.RegisteredVariables[host] == nil {
+			execVariables.RegisteredVariables[host] = make(KeyValueVariable)
+		}
+		mergeVariables(execVariables.RegisteredVariables[host], variables)
+	}
+}
+
+// hostVariables returns a copy of the registered variables of a host
+func (r *registeredResults) hostVariables(host string) KeyValueVariable {
+	if r == nil {
+		return nil
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return maps.Clone(r.variables[host])
+}
+
+// VariableLayer is a source of variables. Layers are merged in this order, each one overriding the previous ones:
+// defaults, builtin, facts, variables, group (by GroupPriority), host, registered, extra.
+type VariableLayer string
+
+const (
+	// VariableLayerBuiltin contains the Host and Groups variables
+	VariableLayerBuiltin VariableLayer = "builtin"
+	// VariableLayerFacts contains the Facts variable, if the facts have been gathered with GatherFacts
+	VariableLayerFacts      VariableLayer = "facts"
+	VariableLayerDefaults   VariableLayer = "defaults"
+	VariableLayerVariables  VariableLayer = "variables"
+	VariableLayerGroup      VariableLayer = "group"
+	VariableLayerHost       VariableLayer = "host"
+	VariableLayerRegistered VariableLayer = "registered"
+	VariableLayerExtra      VariableLayer = "extra"
+)
+
+// VariableOrigin is a value of a variable defined by a layer
+type VariableOrigin struct {
+	Layer VariableLayer
+	// Group is the group defining the value when Layer is VariableLayerGroup
+	Group string
+	Value interface{}
+}
+
+// VariableExplanation explains where the final value of a variable of a host comes from
+type VariableExplanation struct {
+	Value interface{}
+	// Origins contains the values defined by each layer, from the lowest to the highest precedence.
+	// The last one wins, or is merged with the previous ones if DeepMerge is true.
+	Origins []VariableOrigin
+}
+
+// variableLayer contains the variables of a host defined by a layer
+type variableLayer struct {
+	layer     VariableLayer
+	group     string
+	variables KeyValueVariable
+}
+
+// variableLayers returns the layers of variables of execVariables for a host, from the lowest to the highest precedence.
+// hostLayers are inserted between the defaults and the variables.
+func variableLayers(hostConfig HostConfig, execVariables *ExecVariables, hostLayers []variableLayer) []variableLayer {
+	if execVariables == nil {
+		return hostLayers
+	}
+
+	layers := []variableLayer{{layer: VariableLayerDefaults, variables: execVariables.Defaults}}
+	layers = append(layers, hostLayers...)
+	layers = append(layers, variableLayer{layer: VariableLayerVariables, variables: execVariables.Variables})
+
+	// Sort groups by priority, keeping the order of the host groups for the same priority
+	groups := slices.Clone(hostConfig.Groups)
+	sort.SliceStable(groups, func(i, j int) bool {
+		return execVariables.GroupPriority[groups[i]] < execVariables.GroupPriority[groups[j]]
+	})
+	for _, group := range groups {
+		layers = append(layers, variableLayer{layer: VariableLayerGroup, group: group, variables: execVariables.GroupVariables[group]})
+	}
+
+	layers = append(layers, variableLayer{layer: VariableLayerHost, variables: execVariables.HostVariables[hostConfig.Host]})
+
+	layers = append(layers,
+		variableLayer{layer: VariableLayerRegistered, variables: execVariables.RegisteredVariables[hostConfig.Host]},
+		variableLayer{layer: VariableLayerRegistered, variables: execVariables.registered.hostVariables(hostConfig.Host)},
+	)
+
+	return append(layers, variableLayer{layer: VariableLayerExtra, variables: execVariables.ExtraVariables})
+}
+
+// hostVariableLayers returns the layers of the template variables of a host: the layers of execVariables,
+// with the builtin and facts layers overriding the defaults
+func hostVariableLayers(hostConnection HostConnection, execVariables *ExecVariables) []variableLayer {
+	hostConfig := hostConnection.HostConfig
+	hostConfig.Groups = hostConnection.Groups()
+
+	layers := []variableLayer{{layer: VariableLayerBuiltin, variables: KeyValueVariable{
+		"Host":   hostConfig.Host,
+		"Groups": hostConfig.Groups,
+	}}}
+	if facts := hostConnection.Facts(); facts != nil {
+		layers = append(layers, variableLayer{layer: VariableLayerFacts, variables: KeyValueVariable{"Facts": facts}})
+	}
+
+	return variableLayers(hostConfig, execVariables, layers)
 }
 
 // buildVariables will create a map[string]interface{} with all variables defined in execVariables
 // This function will override variables:
-// execVariables.HostVariables will override execVariables.GroupVariables
+// execVariables.ExtraVariables will override execVariables.RegisteredVariables
+// execVariables.RegisteredVariables will override execVariables.HostVariables
+// execVariables.HostVariables will override execVariables.GroupVariables (sorted by execVariables.GroupPriority)
 // execVariables.GroupVariables will override execVariables.Variables
+// execVariables.Variables will override execVariables.Defaults
 func buildVariables(hostConfig HostConfig, execVariables *ExecVariables) map[string]interface{} {
+	return mergeLayers(variableLayers(hostConfig, execVariables, nil), execVariables)
+}
+
+// templateVariables returns the variables of the templates rendered for a host:
+// Host and Groups contain the host name and groups (static and dynamic ones), Facts the facts gathered by GatherFacts (if any).
+// They override execVariables.Defaults, and are overridden by the other variables of buildVariables.
+// SecretRef values are decrypted with execVariables.Secrets. They are redacted from the responses of the host to the running operation,
+// as well as the values of the execVariables.Sensitive variables.
+func templateVariables(hostConnection HostConnection, execVariables *ExecVariables) (map[string]interface{}, error) {
+	variables := mergeLayers(hostVariableLayers(hostConnection, execVariables), execVariables)
 	if execVariables == nil {
-		return make(map[string]interface{})
+		return variables, nil
 	}
 
-	variables := make(KeyValueVariable)
+	for key, value := range variables {
+		resolved, err := resolveSecrets(value, execVariables.Secrets, hostConnection)
+		if err != nil {
+			return nil, err
+		}
+		variables[key] = resolved
+	}
+
+	for _, name := range execVariables.Sensitive {
+		hostConnection.addSensitiveValue(variables[name])
+	}
+
+	return variables, nil
+}
+
+// ExplainVariables returns, for each template variable of host, its final value and the layers defining it
+func (p *Parallexe) ExplainVariables(host string, execVariables *ExecVariables) (map[string]*VariableExplanation, error) {
+	for _, hostConnection := range p.HostConnections {
+		if hostConnection.HostConfig.Host != host {
+			continue
+		}
+
+		layers := hostVariableLayers(hostConnection, execVariables)
+		variables := mergeLayers(layers, execVariables)
 
-	// Build group variables
-	groupVariables := make(KeyValueVariable)
-	for _, group := range hostConfig.Groups {
-		for key, value := range execVariables.GroupVariables[group] {
-			groupVariables[key] = value
+		explanations := make(map[string]*VariableExplanation, len(variables))
+		for key, value := range variables {
+			explanations[key] = &VariableExplanation{Value: value}
 		}
+		for _, layer := range layers {
+			for key, value := range layer.variables {
+				explanations[key].Origins = append(explanations[key].Origins, VariableOrigin{Layer: layer.layer, Group: layer.group, Value: value})
+			}
+		}
+
+		return explanations, nil
 	}
 
-	// Build host variables
-	hostVariables := execVariables.HostVariables[hostConfig.Host]
+	return nil, fmt.Errorf("host %s not found", host)
+}
 
-	// Merge variables
-	mergeVariables(variables, execVariables.Variables)
-	mergeVariables(variables, groupVariables)
-	mergeVariables(variables, hostVariables)
+// mergeLayers merges the variables of layers, deeply if execVariables.DeepMerge is true
+func mergeLayers(layers []variableLayer, execVariables *ExecVariables) map[string]interface{} {
+	variables := make(KeyValueVariable)
+	for _, layer := range layers {
+		if execVariables != nil && execVariables.DeepMerge {
+			deepMergeVariables(variables, layer.variables, execVariables.AppendLists)
+		} else {
+			mergeVariables(variables, layer.variables)
+		}
+	}
 
 	return variables
 }
@@ -47,3 +294,50 @@ func mergeVariables(destination, source KeyValueVariable) {
 		destination[key] = value
 	}
 }
+
+// deepMergeVariables merges source map into destination map, merging the nested maps present in both.
+// If appendLists is true, the lists present in both are concatenated. Source maps are not modified.
+func deepMergeVariables(destination, source KeyValueVariable, appendLists bool) {
+	for key, value := range source {
+		destination[key] = deepMergeValue(destination[key], value, appendLists)
+	}
+}
+
+// deepMergeValue returns the merge of value into previous: a new map if both are maps,
+// a new list if both are lists and appendLists is true, value otherwise
+func deepMergeValue(previous, value interface{}, appendLists bool) interface{} {
+	if previousMap, ok := variableMap(previous); ok {
+		if valueMap, ok := variableMap(value); ok {
+			merged := make(map[string]interface{}, len(previousMap)+len(valueMap))
+			for key, nested := range previousMap {
+				merged[key] = nested
+			}
+			for key, nested := range valueMap {
+				merged[key] = deepMergeValue(merged[key], nested, appendLists)
+			}
+			return merged
+		}
+	}
+
+	if appendLists {
+		if previousList, ok := previous.([]interface{}); ok {
+			if valueList, ok := value.([]interface{}); ok {
+				return append(slices.Clone(previousList), valueList...)
+			}
+		}
+	}
+
+	return value
+}
+
+// variableMap returns value as a map if it is a map of variables (as decoded from YAML or JSON)
+func variableMap(value interface{}) (map[string]interface{}, bool) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return typed, true
+	case KeyValueVariable:
+		return typed, true
+	default:
+		return nil, false
+	}
+}
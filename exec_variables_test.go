@@ -120,3 +120,167 @@ func TestBuildVariables(t *testing.T) {
 		}
 	})
 }
+
+func TestTemplateVariables(t *testing.T) {
+	hostConnection := HostConnection{HostConfig: HostConfig{Host: "100.0.0.1", Groups: []string{"group1"}}}
+
+	variables, _ := templateVariables(hostConnection, nil)
+	if variables["Host"] != "100.0.0.1" {
+		t.Errorf("Expected Host to be '100.0.0.1', got '%v'", variables["Host"])
+	}
+
+	variables, _ = templateVariables(hostConnection, &ExecVariables{Variables: KeyValueVariable{"Host": "web1"}})
+	if variables["Host"] != "web1" {
+		t.Errorf("Expected Host to be overridden, got '%v'", variables["Host"])
+	}
+
+	t.Run("Defaults overridden by builtin and facts", func(t *testing.T) {
+		hostConnection := HostConnection{HostConfig: hostConnection.HostConfig, state: &hostState{}}
+		facts := &Facts{OS: "linux"}
+		hostConnection.setFacts(facts)
+
+		variables, _ := templateVariables(hostConnection, &ExecVariables{
+			Defaults: KeyValueVariable{"Host": "default", "Facts": "default", "var1": "default"},
+		})
+		if variables["Host"] != "100.0.0.1" {
+			t.Errorf("Expected Host to override the defaults, got '%v'", variables["Host"])
+		}
+		if variables["Facts"] != facts {
+			t.Errorf("Expected Facts to override the defaults, got '%v'", variables["Facts"])
+		}
+		if variables["var1"] != "default" {
+			t.Errorf("Expected var1 to be 'default', got '%v'", variables["var1"])
+		}
+	})
+}
+
+func TestRegisteredVariables(t *testing.T) {
+	hostConfig := HostConfig{Host: "100.0.0.1", Groups: []string{"group1"}}
+
+	execVariables := &ExecVariables{
+		HostVariables:       map[string]KeyValueVariable{"100.0.0.1": {"var1": "host"}},
+		RegisteredVariables: map[string]KeyValueVariable{"100.0.0.1": {"var1": "previous", "var3": "previous"}},
+		registered:          newRegisteredResults(),
+	}
+	execVariables.registered.register("100.0.0.1", "var1", newRegisteredResult(&CommandResponse{Stdout: "[1, 2]\n", Code: 0}))
+	execVariables.registered.register("100.0.0.2", "var2", "other host")
+
+	result := buildVariables(hostConfig, execVariables)
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 variables, got %d", len(result))
+	}
+	if result["var3"] != "previous" {
+		t.Errorf("Expected var3 from RegisteredVariables, got '%v'", result["var3"])
+	}
+
+	registered, ok := result["var1"].(*RegisteredResult)
+	if !ok {
+		t.Fatalf("Expected var1 to be overridden by the registered result, got '%v'", result["var1"])
+	}
+
+	if values, ok := registered.Json.([]interface{}); !ok || len(values) != 2 {
+		t.Errorf("Expected stdout to be parsed as JSON, got '%v'", registered.Json)
+	}
+}
+
+func TestVariablePrecedence(t *testing.T) {
+	hostConfig := HostConfig{Host: "100.0.0.1", Groups: []string{"group1", "group2"}}
+
+	t.Run("Group priority", func(t *testing.T) {
+		execVariables := &ExecVariables{
+			GroupVariables: map[string]KeyValueVariable{
+				"group1": {"var1": "group1"},
+				"group2": {"var1": "group2"},
+			},
+		}
+
+		if result := buildVariables(hostConfig, execVariables); result["var1"] != "group2" {
+			t.Errorf("Expected var1 to be 'group2', got '%v'", result["var1"])
+		}
+
+		execVariables.GroupPriority = map[string]int{"group1": 10}
+		if result := buildVariables(hostConfig, execVariables); result["var1"] != "group1" {
+			t.Errorf("Expected var1 to be 'group1', got '%v'", result["var1"])
+		}
+	})
+
+	t.Run("Defaults and extra variables", func(t *testing.T) {
+		result := buildVariables(hostConfig, &ExecVariables{
+			Defaults:       KeyValueVariable{"var1": "default", "var2": "default"},
+			Variables:      KeyValueVariable{"var1": "value1"},
+			HostVariables:  map[string]KeyValueVariable{"100.0.0.1": {"var3": "host"}},
+			ExtraVariables: KeyValueVariable{"var3": "extra"},
+		})
+
+		if result["var1"] != "value1" || result["var2"] != "default" || result["var3"] != "extra" {
+			t.Errorf("Wrong precedence, got %v", result)
+		}
+	})
+
+	t.Run("Deep merge", func(t *testing.T) {
+		execVariables := &ExecVariables{
+			Variables: KeyValueVariable{"app": map[string]interface{}{
+				"port":    8080,
+				"plugins": []interface{}{"auth"},
+			}},
+			HostVariables: map[string]KeyValueVariable{"100.0.0.1": {"app": map[string]interface{}{
+				"debug":   true,
+				"plugins": []interface{}{"metrics"},
+			}}},
+		}
+
+		app := buildVariables(hostConfig, execVariables)["app"].(map[string]interface{})
+		if _, ok := app["port"]; ok {
+			t.Errorf("Expected app to be replaced without DeepMerge, got %v", app)
+		}
+
+		execVariables.DeepMerge = true
+		app = buildVariables(hostConfig, execVariables)["app"].(map[string]interface{})
+		if app["port"] != 8080 || app["debug"] != true || len(app["plugins"].([]interface{})) != 1 {
+			t.Errorf("Expected app to be merged, got %v", app)
+		}
+
+		execVariables.AppendLists = true
+		app = buildVariables(hostConfig, execVariables)["app"].(map[string]interface{})
+		if plugins := app["plugins"].([]interface{}); len(plugins) != 2 || plugins[1] != "metrics" {
+			t.Errorf("Expected plugins to be appended, got %v", plugins)
+		}
+
+		if len(execVariables.Variables["app"].(map[string]interface{})) != 2 {
+			t.Errorf("Expected source variables not to be modified, got %v", execVariables.Variables["app"])
+		}
+	})
+}
+
+func TestExplainVariables(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost", Groups: []string{"web"}}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	execVariables := &ExecVariables{
+		Variables:      KeyValueVariable{"port": 80, "name": "app"},
+		GroupVariables: map[string]KeyValueVariable{"web": {"port": 8080}},
+	}
+
+	explanations, err := pexe.ExplainVariables("localhost", execVariables)
+	if err != nil {
+		t.Fatalf("Error during ExplainVariables: %v", err)
+	}
+
+	port := explanations["port"]
+	if port.Value != 8080 || len(port.Origins) != 2 {
+		t.Fatalf("Wrong explanation of port, got %+v", port)
+	}
+	if port.Origins[0].Layer != VariableLayerVariables || port.Origins[1].Layer != VariableLayerGroup || port.Origins[1].Group != "web" {
+		t.Errorf("Wrong origins of port, got %+v", port.Origins)
+	}
+	if explanations["Host"].Origins[0].Layer != VariableLayerBuiltin {
+		t.Errorf("Expected Host to be a builtin variable, got %+v", explanations["Host"])
+	}
+
+	if _, err := pexe.ExplainVariables("unknown", execVariables); err == nil {
+		t.Errorf("Expected an error for an unknown host")
+	}
+}
@@ -0,0 +1,170 @@
+package parallexe
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFactsTTL is the time the facts of a host are cached when GatherFactsConfig.TTL is zero
+const DefaultFactsTTL = 10 * time.Minute
+
+// Facts contains the system information of a host gathered by GatherFacts
+type Facts struct {
+	Hostname string
+	// OS is the kernel name in lower case (e.g. linux, darwin, freebsd)
+	OS     string
+	Kernel string
+	// Arch is the machine hardware name (e.g. x86_64, aarch64)
+	Arch string
+	// Distribution and DistributionVersion are the ID and VERSION_ID of /etc/os-release (e.g. ubuntu, 22.04)
+	Distribution        string
+	DistributionVersion string
+	// MemoryTotal is the total memory in bytes
+	MemoryTotal uint64
+	CPUs        int
+	// IPAddresses contains the global IP addresses of the host
+	IPAddresses []string
+	// Raw contains all the values printed by the probe, by name
+	Raw map[string]string
+	// GatheredAt is the time the facts have been gathered
+	GatheredAt time.Time
+}
+
+type GatherFactsConfig struct {
+	// ExecConfig allows to filter hosts and groups, and defines how the probe is run
+	ExecConfig *ExecConfig
+	// TTL is the age from which the cached facts of a host are gathered again by GatherFacts. Default is DefaultFactsTTL.
+	// It does not expire the facts: the templates, ExecConfig.FactsFilter and Facts use them until they are gathered again.
+	TTL time.Duration
+	// Refresh gathers the facts even if they are cached
+	Refresh bool
+}
+
+// factsProbe is a POSIX shell script printing the facts of a host as name=value lines.
+// Errors are discarded: a fact which can't be read is empty.
+const factsProbe = `exec 2>/dev/null
+echo "hostname=$(hostname || uname -n)"
+echo "os=$(uname -s | tr '[:upper:]' '[:lower:]')"
+echo "kernel=$(uname -r)"
+echo "arch=$(uname -m)"
+if [ -r /etc/os-release ]; then
+  echo "distribution=$(sed -n 's/^ID=//p' /etc/os-release | tr -d '"')"
+  echo "distribution_version=$(sed -n 's/^VERSION_ID=//p' /etc/os-release | tr -d '"')"
+  echo "distribution_name=$(sed -n 's/^PRETTY_NAME=//p' /etc/os-release | tr -d '"')"
+fi
+if [ -r /proc/meminfo ]; then
+  echo "memory_total=$(awk '/^MemTotal:/ { printf "%.0f", $2 * 1024 }' /proc/meminfo)"
+else
+  echo "memory_total=$(sysctl -n hw.memsize || sysctl -n hw.physmem)"
+fi
+echo "cpus=$(getconf _NPROCESSORS_ONLN || nproc)"
+if command -v ip >/dev/null; then
+  echo "ip_addresses=$(ip -o addr show scope global | awk '{ sub(/\/.*/, "", $4); print $4 }' | tr '\n' ' ')"
+elif command -v ifconfig >/dev/null; then
+  echo "ip_addresses=$(ifconfig | awk '$1 == "inet" || $1 == "inet6" { sub(/addr:/, "", $2); print $2 }' | grep -v -e '^127\.' -e '^::1$' -e '^fe80' | tr '\n' ' ')"
+fi
+exit 0`
+
+// GatherFacts runs a probe on each host to gather its Facts, and caches them in its HostConnection.
+// Cached facts are used by the templates (.Facts) and by ExecConfig.FactsFilter.
+// Hosts whose facts are cached and younger than config.TTL are not probed again, unless config.Refresh is true.
+// The returned CommandResponse of a probed host contains the probe output, with Attempts 1.
+// The one of a host whose facts are cached has Attempts 0, and a Stdout noting the time the facts have been gathered.
+func (p *Parallexe) GatherFacts(config *GatherFactsConfig) (*CommandResponses, error) {
+	if config == nil {
+		config = &GatherFactsConfig{}
+	}
+
+	ttl := config.TTL
+	if ttl <= 0 {
+		ttl = DefaultFactsTTL
+	}
+
+	// White list HostSession to execute only on desired hosts
+	filteredHosts := getFilteredHosts(p.HostConnections, config.ExecConfig)
+	execConfig := scriptExecConfig(config.ExecConfig)
+
+	return executeOnHosts(filteredHosts, execConfig, func(hostConnection HostConnection) *CommandResponse {
+		if facts := hostConnection.Facts(); facts != nil && !config.Refresh && time.Since(facts.GatheredAt) < ttl {
+			return &CommandResponse{
+				Stdout:   fmt.Sprintf("facts cached at %s\n", facts.GatheredAt.Format(time.RFC3339)),
+				Success:  true,
+				Attempts: 0,
+			}
+		}
+
+		commandResponse := executeCommandOnHost(hostConnection, factsProbe, execConfig)
+		commandResponse.Attempts = 1
+		if commandResponse.Error == nil && commandResponse.Stderr == "" {
+			hostConnection.setFacts(parseFacts(commandResponse.Stdout))
+		}
+
+		return commandResponse
+	})
+}
+
+// Facts returns the facts of the host gathered by GatherFacts, or nil if they have not been gathered.
+// The facts are returned whatever their age (see Facts.GatheredAt and GatherFactsConfig.TTL).
+func (hostConnection HostConnection) Facts() *Facts {
+	if hostConnection.state == nil {
+		return nil
+	}
+
+	hostConnection.state.m.Lock()
+	defer hostConnection.state.m.Unlock()
+
+	return hostConnection.state.facts
+}
+
+// setFacts caches the facts of the host
+func (hostConnection HostConnection) setFacts(facts *Facts) {
+	if hostConnection.state == nil {
+		return
+	}
+
+	hostConnection.state.m.Lock()
+	defer hostConnection.state.m.Unlock()
+
+	hostConnection.state.facts = facts
+}
+
+// HostFacts returns the facts of a host gathered by GatherFacts, or nil if they have not been gathered
+func (p *Parallexe) HostFacts(host string) *Facts {
+	for _, hostConnection := range p.HostConnections {
+		if hostConnection.HostConfig.Host == host {
+			return hostConnection.Facts()
+		}
+	}
+
+	return nil
+}
+
+// parseFacts parses the name=value lines printed by factsProbe
+func parseFacts(output string) *Facts {
+	raw := make(map[string]string)
+	for _, line := range splitLines(output) {
+		name, value, found := strings.Cut(line, "=")
+		if found {
+			raw[name] = strings.TrimSpace(value)
+		}
+	}
+
+	memoryTotal, _ := strconv.ParseUint(raw["memory_total"], 10, 64)
+	cpus, _ := strconv.Atoi(raw["cpus"])
+
+	return &Facts{
+		Hostname:            raw["hostname"],
+		OS:                  raw["os"],
+		Kernel:              raw["kernel"],
+		Arch:                raw["arch"],
+		Distribution:        raw["distribution"],
+		DistributionVersion: raw["distribution_version"],
+		MemoryTotal:         memoryTotal,
+		CPUs:                cpus,
+		IPAddresses:         strings.Fields(raw["ip_addresses"]),
+		Raw:                 raw,
+		GatheredAt:          time.Now(),
+	}
+}
@@ -0,0 +1,106 @@
+package parallexe
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseFacts(t *testing.T) {
+	facts := parseFacts("hostname=web1\nos=linux\narch=x86_64\ndistribution=ubuntu\ndistribution_version=22.04\n" +
+		"memory_total=8589934592\ncpus=4\nip_addresses=10.0.0.1 fd00::1 \ninvalid line\n")
+
+	if facts.Hostname != "web1" || facts.OS != "linux" || facts.Arch != "x86_64" {
+		t.Errorf("Wrong system facts, got %+v", facts)
+	}
+	if facts.Distribution != "ubuntu" || facts.DistributionVersion != "22.04" {
+		t.Errorf("Wrong distribution facts, got %+v", facts)
+	}
+	if facts.MemoryTotal != 8589934592 || facts.CPUs != 4 {
+		t.Errorf("Wrong hardware facts, got %+v", facts)
+	}
+	if len(facts.IPAddresses) != 2 || facts.IPAddresses[1] != "fd00::1" {
+		t.Errorf("Wrong IP addresses, got %v", facts.IPAddresses)
+	}
+	if len(facts.Raw) != 8 {
+		t.Errorf("Expected 8 raw facts, got %d", len(facts.Raw))
+	}
+}
+
+func TestGatherFacts(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	if pexe.HostFacts("localhost") != nil {
+		t.Fatalf("Expected no facts before GatherFacts")
+	}
+
+	_, err = pexe.GatherFacts(&GatherFactsConfig{ExecConfig: &ExecConfig{Hosts: []string{"localhost"}}})
+	if err != nil {
+		t.Fatalf("Error during GatherFacts: %v", err)
+	}
+
+	facts := pexe.HostFacts("localhost")
+	if facts == nil {
+		t.Fatalf("Expected facts to be cached")
+	}
+	if facts.OS != runtime.GOOS || facts.Kernel == "" || facts.CPUs < 1 {
+		t.Errorf("Wrong facts, got %+v", facts)
+	}
+
+	t.Run("Cached facts", func(t *testing.T) {
+		responses, err := pexe.GatherFacts(&GatherFactsConfig{ExecConfig: &ExecConfig{Hosts: []string{"localhost"}}})
+		if err != nil {
+			t.Fatalf("Error during GatherFacts: %v", err)
+		}
+
+		if pexe.HostFacts("localhost") != facts {
+			t.Errorf("Expected cached facts to be used")
+		}
+		response := responses.HostResponses["localhost"]
+		if response.Attempts != 0 || !strings.HasPrefix(response.Stdout, "facts cached at ") {
+			t.Errorf("Expected a cached response, got %d attempts and %q", response.Attempts, response.Stdout)
+		}
+
+		responses, err = pexe.GatherFacts(&GatherFactsConfig{ExecConfig: &ExecConfig{Hosts: []string{"localhost"}}, Refresh: true})
+		if err != nil {
+			t.Fatalf("Error during GatherFacts: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Attempts != 1 {
+			t.Errorf("Expected the probe to run once, got %d attempts", responses.HostResponses["localhost"].Attempts)
+		}
+
+		if pexe.HostFacts("localhost") == facts {
+			t.Errorf("Expected facts to be gathered again")
+		}
+	})
+
+	t.Run("Facts in templates", func(t *testing.T) {
+		responses, err := pexe.Exec("echo {{ .Facts.OS }}", &ExecConfig{Hosts: []string{"localhost"}, CompileTemplate: true})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != runtime.GOOS+"\n" {
+			t.Errorf("Expected OS fact, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("Hosts filtered by facts", func(t *testing.T) {
+		responses, err := pexe.Exec("echo", &ExecConfig{FactsFilter: func(facts *Facts) bool {
+			return facts.OS == runtime.GOOS
+		}})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		// Facts of 127.0.0.1 have not been gathered
+		if len(responses.HostResponses) != 1 || responses.HostResponses["localhost"] == nil {
+			t.Errorf("Expected only localhost to be selected, got %v", responses.HostResponses)
+		}
+	})
+}
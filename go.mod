@@ -3,8 +3,11 @@ module github.com/parallexe/parallexe
 go 1.19
 
 require (
+	filippo.io/age v1.1.1
 	golang.org/x/crypto v0.8.0
 	golang.org/x/exp v0.0.0-20230420155640-133eef4313cb
+	golang.org/x/term v0.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/sys v0.7.0 // indirect
@@ -0,0 +1,141 @@
+package parallexe
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// groupNameInvalidChars matches the characters replaced by _ in the names of dynamic groups
+var groupNameInvalidChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// GroupByFacts adds each host filtered by execConfig to the dynamic groups returned by groups for its facts.
+// If groups is nil, DefaultFactsGroups is used. Hosts whose facts have not been gathered with GatherFacts are ignored.
+// Dynamic groups can be used in ExecConfig.Groups and ExecVariables.GroupVariables as static groups.
+func (p *Parallexe) GroupByFacts(groups func(facts *Facts) []string, execConfig *ExecConfig) {
+	if groups == nil {
+		groups = DefaultFactsGroups
+	}
+
+	for _, hostConnection := range getFilteredHosts(p.HostConnections, execConfig) {
+		if facts := hostConnection.Facts(); facts != nil {
+			hostConnection.addGroups(groups(facts))
+		}
+	}
+}
+
+// DefaultFactsGroups returns the groups of a host from its facts:
+// os_<os>, os_<distribution>, os_<distribution>_<version> and arch_<arch> (e.g. os_linux, os_ubuntu, os_ubuntu_22_04, arch_x86_64)
+func DefaultFactsGroups(facts *Facts) []string {
+	groups := make([]string, 0)
+
+	if facts.OS != "" {
+		groups = append(groups, GroupName("os", facts.OS))
+	}
+	if facts.Distribution != "" {
+		groups = append(groups, GroupName("os", facts.Distribution))
+		if facts.DistributionVersion != "" {
+			groups = append(groups, GroupName("os", facts.Distribution, facts.DistributionVersion))
+		}
+	}
+	if facts.Arch != "" {
+		groups = append(groups, GroupName("arch", facts.Arch))
+	}
+
+	return groups
+}
+
+// GroupByCommand executes command on the hosts filtered by execConfig, as Exec, and adds each host to a dynamic group
+// per line of its output, named prefix_<line> (e.g. prefix role and output "db" give role_db).
+// Hosts where the command fails are not grouped.
+func (p *Parallexe) GroupByCommand(command string, prefix string, execConfig *ExecConfig) (*CommandResponses, error) {
+	responses, err := p.Exec(command, execConfig)
+	if responses == nil {
+		return nil, err
+	}
+
+	for _, hostConnection := range getFilteredHosts(p.HostConnections, execConfig) {
+		response := responses.HostResponses[hostConnection.HostConfig.Host]
+		if response == nil || response.Error != nil || response.Stderr != "" || response.Code != 0 {
+			continue
+		}
+
+		groups := make([]string, 0)
+		for _, line := range splitLines(response.Stdout) {
+			if group := GroupName(prefix, line); group != "" {
+				groups = append(groups, group)
+			}
+		}
+		hostConnection.addGroups(groups)
+	}
+
+	return responses, err
+}
+
+// ClearDynamicGroups removes the dynamic groups of all hosts
+func (p *Parallexe) ClearDynamicGroups() {
+	for _, hostConnection := range p.HostConnections {
+		if hostConnection.state == nil {
+			continue
+		}
+
+		hostConnection.state.m.Lock()
+		hostConnection.state.dynamicGroups = nil
+		hostConnection.state.m.Unlock()
+	}
+}
+
+// GroupName returns the name of a dynamic group made of parts joined with _.
+// Parts are lower-cased and their characters other than letters, digits and _ are replaced by _.
+func GroupName(parts ...string) string {
+	nameParts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.Trim(groupNameInvalidChars.ReplaceAllString(strings.ToLower(part), "_"), "_")
+		if part != "" {
+			nameParts = append(nameParts, part)
+		}
+	}
+
+	return strings.Join(nameParts, "_")
+}
+
+// Groups returns the static groups of the host followed by its dynamic groups
+func (hostConnection HostConnection) Groups() []string {
+	if hostConnection.state == nil {
+		return hostConnection.HostConfig.Groups
+	}
+
+	hostConnection.state.m.Lock()
+	defer hostConnection.state.m.Unlock()
+
+	if len(hostConnection.state.dynamicGroups) == 0 {
+		return hostConnection.HostConfig.Groups
+	}
+
+	groups := make([]string, 0, len(hostConnection.HostConfig.Groups)+len(hostConnection.state.dynamicGroups))
+	groups = append(groups, hostConnection.HostConfig.Groups...)
+	for _, group := range hostConnection.state.dynamicGroups {
+		if !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// addGroups adds dynamic groups to the host
+func (hostConnection HostConnection) addGroups(groups []string) {
+	if hostConnection.state == nil {
+		return
+	}
+
+	hostConnection.state.m.Lock()
+	defer hostConnection.state.m.Unlock()
+
+	for _, group := range groups {
+		if !slices.Contains(hostConnection.state.dynamicGroups, group) {
+			hostConnection.state.dynamicGroups = append(hostConnection.state.dynamicGroups, group)
+		}
+	}
+}
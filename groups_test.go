@@ -0,0 +1,82 @@
+package parallexe
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestGroupName(t *testing.T) {
+	groupNames := map[string][]string{
+		"os_ubuntu":       {"os", "Ubuntu"},
+		"os_ubuntu_22_04": {"os", "ubuntu", "22.04"},
+		"arch_x86_64":     {"arch", "x86_64"},
+		"role_db":         {"role", " db "},
+		"web":             {"", "web"},
+	}
+
+	for expected, parts := range groupNames {
+		if name := GroupName(parts...); name != expected {
+			t.Errorf("Expected group %s for %v, got %s", expected, parts, name)
+		}
+	}
+}
+
+func TestDynamicGroups(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost", Groups: []string{"web"}}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	pexe.HostConnections[0].setFacts(&Facts{OS: "linux", Distribution: "ubuntu", DistributionVersion: "22.04", Arch: "aarch64"})
+	pexe.GroupByFacts(nil, nil)
+
+	expected := []string{"web", "os_linux", "os_ubuntu", "os_ubuntu_22_04", "arch_aarch64"}
+	if groups := pexe.HostConnections[0].Groups(); !slices.Equal(groups, expected) {
+		t.Fatalf("Expected groups %v, got %v", expected, groups)
+	}
+	if groups := pexe.HostConnections[1].Groups(); len(groups) != 0 {
+		t.Errorf("Expected no groups without facts, got %v", groups)
+	}
+
+	t.Run("Group by command", func(t *testing.T) {
+		_, err := pexe.GroupByCommand("echo db; echo Cache", "role", &ExecConfig{Hosts: []string{"127.0.0.1"}})
+		if err != nil {
+			t.Fatalf("Error during GroupByCommand: %v", err)
+		}
+
+		if groups := pexe.HostConnections[1].Groups(); !slices.Equal(groups, []string{"role_db", "role_cache"}) {
+			t.Errorf("Expected command groups, got %v", groups)
+		}
+	})
+
+	t.Run("Dynamic groups in ExecConfig and variables", func(t *testing.T) {
+		responses, err := pexe.Exec("echo {{ .name }}", &ExecConfig{
+			Groups:          []string{"os_ubuntu", "role_db"},
+			CompileTemplate: true,
+			ExecVariables: &ExecVariables{
+				Variables:      KeyValueVariable{"name": "default"},
+				GroupVariables: map[string]KeyValueVariable{"role_db": {"name": "database"}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		if len(responses.HostResponses) != 2 {
+			t.Fatalf("Expected 2 hosts, got %v", responses.HostResponses)
+		}
+		if responses.HostResponses["localhost"].Stdout != "default\n" || responses.HostResponses["127.0.0.1"].Stdout != "database\n" {
+			t.Errorf("Wrong variables, got %q and %q", responses.HostResponses["localhost"].Stdout, responses.HostResponses["127.0.0.1"].Stdout)
+		}
+	})
+
+	t.Run("Clear dynamic groups", func(t *testing.T) {
+		pexe.ClearDynamicGroups()
+
+		if groups := pexe.HostConnections[0].Groups(); !slices.Equal(groups, []string{"web"}) {
+			t.Errorf("Expected static groups only, got %v", groups)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package parallexe
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+const (
+	defaultPtyTerm   = "xterm"
+	defaultPtyWidth  = 80
+	defaultPtyHeight = 40
+)
+
+// commandInput contains the stdin and PTY of the commands run by Exec and MultiExec.
+// It is prepared once by prepareCommandInput, so it is not used by the commands run internally (Send, LineInFile, ...).
+type commandInput struct {
+	// hostStdin contains the stdin of each host, read in memory so it can be sent to several commands
+	hostStdin map[string][]byte
+	pty       bool
+}
+
+// prepareCommandInput returns a copy of execConfig with the stdin of each host read in memory.
+// execConfig.Stdin is read once and sent to all hosts, unless a host has its own reader in execConfig.HostStdin.
+func prepareCommandInput(execConfig *ExecConfig, hostConnections []HostConnection) (*ExecConfig, error) {
+	if execConfig == nil || (execConfig.Stdin == nil && len(execConfig.HostStdin) == 0 && !execConfig.Pty) {
+		return execConfig, nil
+	}
+
+	input := &commandInput{
+		hostStdin: make(map[string][]byte),
+		pty:       execConfig.Pty,
+	}
+
+	var sharedStdin []byte
+	if execConfig.Stdin != nil {
+		data, err := io.ReadAll(execConfig.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("can't read stdin: %v", err)
+		}
+		sharedStdin = data
+	}
+
+	for _, hostConnection := range hostConnections {
+		host := hostConnection.HostConfig.Host
+
+		if reader, ok := execConfig.HostStdin[host]; ok {
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, fmt.Errorf("can't read stdin of host %s: %v", host, err)
+			}
+			input.hostStdin[host] = data
+		} else if sharedStdin != nil {
+			input.hostStdin[host] = sharedStdin
+		}
+	}
+
+	preparedConfig := *execConfig
+	preparedConfig.input = input
+
+	return &preparedConfig, nil
+}
+
+// hostStdin returns the prepared stdin of host, or nil if there is none
+func hostStdin(execConfig *ExecConfig, host string) io.Reader {
+	if execConfig == nil || execConfig.input == nil {
+		return nil
+	}
+
+	data, ok := execConfig.input.hostStdin[host]
+	if !ok {
+		return nil
+	}
+
+	return bytes.NewReader(data)
+}
+
+// ptyRequested returns true if a PTY must be allocated for the command
+func ptyRequested(execConfig *ExecConfig) bool {
+	return (execConfig != nil && execConfig.input != nil && execConfig.input.pty) || becomePasswordOnPrompt(execConfig)
+}
+
+// ptyExitError returns the error of a command that exited with a non-zero code in a PTY.
+// Its stderr is merged into its stdout by the PTY, so the exit code is the only sign that it failed.
+func ptyExitError(code int, execConfig *ExecConfig) error {
+	if code == 0 || !ptyRequested(execConfig) {
+		return nil
+	}
+
+	return fmt.Errorf("command exited with code %d", code)
+}
+
+// ptySize returns the terminal type and size of the PTY
+func ptySize(execConfig *ExecConfig) (string, int, int) {
+	term, width, height := defaultPtyTerm, defaultPtyWidth, defaultPtyHeight
+
+	if execConfig != nil {
+		if execConfig.PtyTerm != "" {
+			term = execConfig.PtyTerm
+		}
+		if execConfig.PtyWidth > 0 {
+			width = execConfig.PtyWidth
+		}
+		if execConfig.PtyHeight > 0 {
+			height = execConfig.PtyHeight
+		}
+	}
+
+	return term, width, height
+}
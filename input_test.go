@@ -0,0 +1,142 @@
+package parallexe
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExecStdin(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	t.Run("Stdin is sent to all hosts", func(t *testing.T) {
+		responses, err := pexe.Exec("cat", &ExecConfig{Stdin: strings.NewReader("SELECT 1;\n")})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		for host, response := range responses.HostResponses {
+			if response.Stdout != "SELECT 1;\n" {
+				t.Errorf("Expected stdin on host %s, got %q", host, response.Stdout)
+			}
+		}
+	})
+
+	t.Run("Stdin per host", func(t *testing.T) {
+		responses, err := pexe.Exec("cat", &ExecConfig{
+			Stdin:     strings.NewReader("shared"),
+			HostStdin: map[string]io.Reader{"127.0.0.1": strings.NewReader("own")},
+		})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "shared" {
+			t.Errorf("Expected shared stdin on localhost, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+		if responses.HostResponses["127.0.0.1"].Stdout != "own" {
+			t.Errorf("Expected own stdin on 127.0.0.1, got %q", responses.HostResponses["127.0.0.1"].Stdout)
+		}
+	})
+
+	t.Run("Stdin is sent to each command", func(t *testing.T) {
+		responses, err := pexe.MultiExec([]string{"wc -l", "cat"}, &ExecConfig{
+			Hosts: []string{"localhost"},
+			Stdin: strings.NewReader("a\nb\n"),
+		})
+		if err != nil {
+			t.Fatalf("Error during MultiExec: %v", err)
+		}
+
+		if strings.TrimSpace(responses[0].HostResponses["localhost"].Stdout) != "2" {
+			t.Errorf("Expected 2 lines, got %q", responses[0].HostResponses["localhost"].Stdout)
+		}
+		if responses[1].HostResponses["localhost"].Stdout != "a\nb\n" {
+			t.Errorf("Expected stdin, got %q", responses[1].HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("No stdin", func(t *testing.T) {
+		responses, err := pexe.Exec("cat", nil)
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "" {
+			t.Errorf("Expected no output, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("PTY is not supported on localhost", func(t *testing.T) {
+		responses, err := pexe.Exec("tty", &ExecConfig{Pty: true})
+		if err == nil {
+			t.Fatalf("Expected an error with a PTY on localhost")
+		}
+
+		if responses.HostResponses["localhost"].Error == nil {
+			t.Errorf("Expected an error response on localhost")
+		}
+	})
+}
+
+func TestPtySize(t *testing.T) {
+	term, width, height := ptySize(nil)
+	if term != "xterm" || width != 80 || height != 40 {
+		t.Errorf("Expected xterm 80x40, got %s %dx%d", term, width, height)
+	}
+
+	term, width, height = ptySize(&ExecConfig{PtyTerm: "vt100", PtyWidth: 200, PtyHeight: 50})
+	if term != "vt100" || width != 200 || height != 50 {
+		t.Errorf("Expected vt100 200x50, got %s %dx%d", term, width, height)
+	}
+}
+
+func TestHostStdin(t *testing.T) {
+	execConfig, err := prepareCommandInput(&ExecConfig{
+		Become:         true,
+		BecomePassword: "secret",
+		Stdin:          strings.NewReader("input"),
+	}, []HostConnection{{HostConfig: HostConfig{Host: "localhost"}}})
+	if err != nil {
+		t.Fatalf("Error during input preparation: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		data, err := io.ReadAll(hostStdin(execConfig, "localhost"))
+		if err != nil {
+			t.Fatalf("Error during stdin read: %v", err)
+		}
+
+		// The sudo password is only written when it is prompted
+		if string(data) != "input" {
+			t.Errorf("Expected input, got %q", string(data))
+		}
+	}
+
+	if hostStdin(nil, "localhost") != nil {
+		t.Errorf("Expected no stdin without config")
+	}
+}
+
+func TestPtyExitError(t *testing.T) {
+	execConfig, err := prepareCommandInput(&ExecConfig{Pty: true}, []HostConnection{{HostConfig: HostConfig{Host: "localhost"}}})
+	if err != nil {
+		t.Fatalf("Error during input preparation: %v", err)
+	}
+
+	if err := ptyExitError(1, execConfig); err == nil || err.Error() != "command exited with code 1" {
+		t.Errorf("Expected an exit code error with a PTY, got %v", err)
+	}
+	if err := ptyExitError(0, execConfig); err != nil {
+		t.Errorf("Expected no error on success with a PTY, got %v", err)
+	}
+
+	// Without PTY, the failures are reported by the exit code and stderr
+	if err := ptyExitError(1, &ExecConfig{}); err != nil {
+		t.Errorf("Expected no error without PTY, got %v", err)
+	}
+}
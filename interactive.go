@@ -0,0 +1,175 @@
+package parallexe
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// terminal gives access to the local terminal. It is replaced in tests, which have no terminal.
+type terminal interface {
+	IsTerminal(fd int) bool
+	GetSize(fd int) (width, height int, err error)
+	MakeRaw(fd int) (*term.State, error)
+	Restore(fd int, state *term.State) error
+}
+
+// xTerminal is the terminal implemented by golang.org/x/term
+type xTerminal struct{}
+
+func (xTerminal) IsTerminal(fd int) bool                  { return term.IsTerminal(fd) }
+func (xTerminal) GetSize(fd int) (int, int, error)        { return term.GetSize(fd) }
+func (xTerminal) MakeRaw(fd int) (*term.State, error)     { return term.MakeRaw(fd) }
+func (xTerminal) Restore(fd int, state *term.State) error { return term.Restore(fd, state) }
+
+// localTerminal is the terminal attached to the remote commands run by Interactive
+var localTerminal terminal = xTerminal{}
+
+// Interactive attaches the local terminal to a command run on a single host, until it exits.
+// If command is empty, the login shell of the user is started (execConfig.Shell or sh on localhost).
+// On remote hosts, a PTY with the size of the local terminal is requested and the local terminal is put in raw mode.
+// execConfig.Hosts, Groups, Stdin and Pty are ignored. With Become, the password is typed by the user when prompted.
+// An error is returned if host is unknown or configured more than once.
+func (p *Parallexe) Interactive(host string, command string, execConfig *ExecConfig) error {
+	if err := validateEnvironment(execConfig); err != nil {
+		return err
+	}
+
+	hostConnection, err := p.interactiveHost(host)
+	if err != nil {
+		return err
+	}
+
+	if hostConnection.Client == nil {
+		return localInteractive(command, execConfig)
+	}
+
+	return remoteInteractive(hostConnection, command, execConfig)
+}
+
+// interactiveHost returns the connection of host, which must be configured once
+func (p *Parallexe) interactiveHost(host string) (HostConnection, error) {
+	hostConnections := getSelectedHosts(p.HostConnections, &ExecConfig{Hosts: []string{host}})
+
+	switch len(hostConnections) {
+	case 0:
+		return HostConnection{}, fmt.Errorf("host %s not found", host)
+	case 1:
+		return hostConnections[0], nil
+	default:
+		return HostConnection{}, fmt.Errorf("host %s is configured %d times, an interactive command runs on a single host", host, len(hostConnections))
+	}
+}
+
+// localInteractive runs command locally with the standard input and outputs of the process
+func localInteractive(command string, execConfig *ExecConfig) error {
+	shell := "sh"
+	if execConfig != nil && execConfig.Shell != "" {
+		shell = execConfig.Shell
+	}
+
+	var cmd *exec.Cmd
+	switch {
+	case execConfig != nil && execConfig.Become:
+		if command == "" {
+			command = shell
+		}
+
+		becomeCmd, err := becomeCommand(shellCommand(command, execConfig, true), execConfig)
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command("sh", "-c", becomeCmd)
+	case command == "":
+		cmd = exec.Command(shell)
+	default:
+		cmd = exec.Command(shell, "-c", command)
+	}
+
+	if execConfig != nil && !execConfig.Become {
+		cmd.Dir = execConfig.Dir
+		cmd.Env = localEnv(execConfig.Env)
+	}
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// prepareTerminal returns the type and size of the PTY requested for the terminal fd.
+// If fd is a terminal, its size is used and it is put in raw mode until restore is called.
+// Otherwise, the PTY is configured by execConfig as in Exec.
+func prepareTerminal(fd int, execConfig *ExecConfig) (ptyTerm string, width int, height int, restore func(), err error) {
+	ptyTerm, width, height = ptySize(execConfig)
+	restore = func() {}
+
+	if !localTerminal.IsTerminal(fd) {
+		return ptyTerm, width, height, restore, nil
+	}
+
+	if width, height, err = localTerminal.GetSize(fd); err != nil {
+		return "", 0, 0, nil, fmt.Errorf("can't get terminal size: %v", err)
+	}
+
+	state, err := localTerminal.MakeRaw(fd)
+	if err != nil {
+		return "", 0, 0, nil, fmt.Errorf("can't set terminal in raw mode: %v", err)
+	}
+
+	return ptyTerm, width, height, func() { localTerminal.Restore(fd, state) }, nil
+}
+
+// remoteInteractive runs command on a remote host in a PTY attached to the local terminal
+func remoteInteractive(hostConnection HostConnection, command string, execConfig *ExecConfig) error {
+	session, err := hostConnection.Client.NewSession()
+	if err != nil {
+		return fmt.Errorf("can't open SSH connection: %v", err)
+	}
+	defer session.Close()
+
+	envPrefix := false
+	if execConfig != nil {
+		envPrefix = execConfig.Become || !setSessionEnv(session, execConfig.Env)
+	}
+
+	ptyTerm, width, height, restore, err := prepareTerminal(int(os.Stdin.Fd()), execConfig)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	if err := session.RequestPty(ptyTerm, height, width, ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
+		return fmt.Errorf("can't request PTY: %v", err)
+	}
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	if command == "" && (execConfig == nil || (execConfig.Shell == "" && execConfig.Dir == "" && !execConfig.Become && !envPrefix)) {
+		if err := session.Shell(); err != nil {
+			return fmt.Errorf("can't start shell: %v", err)
+		}
+
+		return session.Wait()
+	}
+
+	if command == "" {
+		command = "${SHELL:-sh}"
+		if execConfig.Shell != "" {
+			command = ShellQuote(execConfig.Shell)
+		}
+	}
+
+	cmd, err := becomeCommand(shellCommand(command, execConfig, envPrefix), execConfig)
+	if err != nil {
+		return err
+	}
+
+	return session.Run(cmd)
+}
@@ -0,0 +1,114 @@
+package parallexe
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/term"
+)
+
+// fakeTerminal is a terminal of a given size, recording whether it is in raw mode
+type fakeTerminal struct {
+	width, height int
+	sizeErr       error
+	raw           bool
+}
+
+func (f *fakeTerminal) IsTerminal(fd int) bool { return true }
+func (f *fakeTerminal) GetSize(fd int) (int, int, error) {
+	return f.width, f.height, f.sizeErr
+}
+func (f *fakeTerminal) MakeRaw(fd int) (*term.State, error) {
+	f.raw = true
+	return nil, nil
+}
+func (f *fakeTerminal) Restore(fd int, state *term.State) error {
+	f.raw = false
+	return nil
+}
+
+func TestInteractive(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}, {Host: "127.0.0.1"}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	errorCases := map[string]struct {
+		host       string
+		execConfig *ExecConfig
+		message    string
+	}{
+		"Unknown host":                {"unknown", nil, "host unknown not found"},
+		"More than one host":          {"127.0.0.1", nil, "configured 2 times"},
+		"Invalid environment":         {"localhost", &ExecConfig{Env: map[string]string{"A-B": "1"}}, "invalid environment variable name"},
+		"Unsupported become user":     {"localhost", &ExecConfig{Become: true, BecomeUser: "root; id"}, "invalid become user"},
+		"Failed command on localhost": {"localhost", nil, "exit status 3"},
+	}
+
+	for name, errorCase := range errorCases {
+		t.Run(name, func(t *testing.T) {
+			err := pexe.Interactive(errorCase.host, "exit 3", errorCase.execConfig)
+			if err == nil || !strings.Contains(err.Error(), errorCase.message) {
+				t.Errorf("Expected an error containing %q, got %v", errorCase.message, err)
+			}
+		})
+	}
+
+	t.Run("Command on localhost", func(t *testing.T) {
+		if err := pexe.Interactive("localhost", "true", nil); err != nil {
+			t.Errorf("Error during Interactive: %v", err)
+		}
+	})
+}
+
+func TestPrepareTerminal(t *testing.T) {
+	defer func() { localTerminal = xTerminal{} }()
+
+	t.Run("Not a terminal", func(t *testing.T) {
+		localTerminal = xTerminal{}
+
+		// -1 is never a terminal
+		ptyTerm, width, height, restore, err := prepareTerminal(-1, &ExecConfig{PtyTerm: "vt100", PtyWidth: 132})
+		if err != nil {
+			t.Fatalf("Error during terminal preparation: %v", err)
+		}
+		restore()
+
+		if ptyTerm != "vt100" || width != 132 || height != defaultPtyHeight {
+			t.Errorf("Expected the PTY of execConfig, got %s %dx%d", ptyTerm, width, height)
+		}
+	})
+
+	t.Run("Terminal size and raw mode", func(t *testing.T) {
+		fake := &fakeTerminal{width: 200, height: 50}
+		localTerminal = fake
+
+		ptyTerm, width, height, restore, err := prepareTerminal(0, &ExecConfig{PtyWidth: 132})
+		if err != nil {
+			t.Fatalf("Error during terminal preparation: %v", err)
+		}
+
+		if ptyTerm != defaultPtyTerm || width != 200 || height != 50 {
+			t.Errorf("Expected the size of the terminal, got %s %dx%d", ptyTerm, width, height)
+		}
+		if !fake.raw {
+			t.Errorf("Expected the terminal to be in raw mode")
+		}
+
+		restore()
+		if fake.raw {
+			t.Errorf("Expected the terminal to be restored")
+		}
+	})
+
+	t.Run("Terminal size error", func(t *testing.T) {
+		fake := &fakeTerminal{sizeErr: errors.New("no size")}
+		localTerminal = fake
+
+		if _, _, _, _, err := prepareTerminal(0, nil); err == nil || fake.raw {
+			t.Errorf("Expected an error without raw mode, got %v", err)
+		}
+	})
+}
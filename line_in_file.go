@@ -1,27 +1,215 @@
 package parallexe
 
-import "fmt"
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// InsertBOF can be used as LineInFileConfig.InsertBefore to insert the line at the beginning of the file
+	InsertBOF = "BOF"
+	// InsertEOF can be used as LineInFileConfig.InsertAfter to insert the line at the end of the file
+	InsertEOF = "EOF"
+)
 
 type LineInFileConfig struct {
 	// ExecConfig allows to filter hosts and groups
 	ExecConfig *ExecConfig
-	Absent     bool
+	// Absent removes the line instead of ensuring it is present.
+	// If Regexp is set, all lines matching Regexp are removed, otherwise all lines equal to line are removed.
+	Absent bool
+	// Regexp is the regular expression used to find the line to replace.
+	// The last matching line is replaced by line. If no line matches, line is inserted as defined by InsertAfter and InsertBefore.
+	Regexp string
+	// InsertAfter is a regular expression: line is inserted after the last matching line.
+	// InsertEOF (default) inserts line at the end of the file, as well as when no line matches.
+	InsertAfter string
+	// InsertBefore is a regular expression: line is inserted before the last matching line.
+	// InsertBOF inserts line at the beginning of the file. If no line matches, line is inserted at the end of the file.
+	InsertBefore string
+	// BackRefs allows line to contain references to Regexp groups ($1, ${name}).
+	// If no line matches Regexp, the file is left unchanged.
+	BackRefs bool
+	// NoCreate returns an error for hosts where the file does not exist, instead of creating it with the line
+	NoCreate bool
+	// Backup copies the file before changing it. The path of the backup file is returned in CommandResponse.Stdout.
+	Backup bool
+}
+
+// lineInFileRules contains the compiled regular expressions of a LineInFileConfig
+type lineInFileRules struct {
+	regexp       *regexp.Regexp
+	insertAfter  *regexp.Regexp
+	insertBefore *regexp.Regexp
+	insertBOF    bool
 }
 
 // LineInFile checks if a line is present in a file.
 // If absent is true, the line will be removed from the file if it exists or nothing will be done.
-// If absent is false, if file does not exist, it will be created with the line.
+// If absent is false, the line is added, or replaces the line matching config.Regexp.
+// If the file does not exist, it will be created with the line, unless config.NoCreate is true.
+// The file is read and edited locally, then written back on each host only if it changed:
+// CommandResponse.Changed and CommandResponse.Diff report the changes per host.
 func (p *Parallexe) LineInFile(path string, line string, config *LineInFileConfig) (*CommandResponses, error) {
-	commands := ""
+	rules, err := compileLineInFileRules(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// White list HostSession to execute only on desired hosts
+	filteredHosts := getFilteredHosts(p.HostConnections, config.ExecConfig)
+
+	options := editFileOptions{Create: !config.NoCreate, Backup: config.Backup}
+
+	return editFileOnHosts(filteredHosts, path, options, config.ExecConfig, func(hostConnection HostConnection, content string, exists bool) (string, error) {
+		return editLineInFile(content, line, config, rules), nil
+	})
+}
+
+// compileLineInFileRules validates config and compiles its regular expressions
+func compileLineInFileRules(config *LineInFileConfig) (*lineInFileRules, error) {
+	var rules lineInFileRules
+	var err error
+
+	if config.InsertAfter != "" && config.InsertBefore != "" {
+		return nil, fmt.Errorf("InsertAfter and InsertBefore can't be used together")
+	}
+
+	if config.BackRefs && config.Regexp == "" {
+		return nil, fmt.Errorf("BackRefs requires Regexp")
+	}
+
+	if config.Regexp != "" {
+		rules.regexp, err = regexp.Compile(config.Regexp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Regexp: %v", err)
+		}
+	}
+
+	if config.InsertAfter != "" && config.InsertAfter != InsertEOF {
+		rules.insertAfter, err = regexp.Compile(config.InsertAfter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid InsertAfter: %v", err)
+		}
+	}
+
+	if config.InsertBefore == InsertBOF {
+		rules.insertBOF = true
+	} else if config.InsertBefore != "" {
+		rules.insertBefore, err = regexp.Compile(config.InsertBefore)
+		if err != nil {
+			return nil, fmt.Errorf("invalid InsertBefore: %v", err)
+		}
+	}
+
+	return &rules, nil
+}
+
+// editLineInFile returns content edited as defined by config.
+// The returned content is unchanged if the line is already as expected.
+func editLineInFile(content string, line string, config *LineInFileConfig, rules *lineInFileRules) string {
+	lines := splitFileLines(content)
 
 	if config.Absent {
-		// If file exists, remove line from file
-		// Otherwise, do nothing
-		commands = fmt.Sprintf("sed '/%s/d' %s > %s.tmp 2>/dev/null && mv %s.tmp %s ", line, path, path, path, path)
-	} else {
-		// Append the line to the file or create the file if it does not exist
-		commands = fmt.Sprintf("[ -f %s ] || printf '%s' > %s; grep -qF \"%s\" %s || printf '\n%s' >> %s", path, line, path, line, path, line, path)
+		keptLines := make([]string, 0, len(lines))
+		for _, fileLine := range lines {
+			if (rules.regexp != nil && rules.regexp.MatchString(fileLine)) || (rules.regexp == nil && fileLine == line) {
+				continue
+			}
+			keptLines = append(keptLines, fileLine)
+		}
+
+		if len(keptLines) == len(lines) {
+			return content
+		}
+		return joinFileLines(keptLines)
+	}
+
+	if rules.regexp != nil {
+		if index := lastMatchingLine(lines, rules.regexp); index >= 0 {
+			newLine := line
+			if config.BackRefs {
+				newLine = expandBackRefs(rules.regexp, lines[index], line)
+			}
+
+			if lines[index] == newLine {
+				return content
+			}
+
+			lines[index] = newLine
+			return joinFileLines(lines)
+		}
+
+		if config.BackRefs {
+			return content
+		}
+	}
+
+	// Nothing to do if the line is already present
+	for _, fileLine := range lines {
+		if fileLine == line {
+			return content
+		}
+	}
+
+	index := insertionIndex(lines, rules)
+	lines = append(lines[:index], append([]string{line}, lines[index:]...)...)
+
+	return joinFileLines(lines)
+}
+
+// insertionIndex returns the index where new lines must be inserted as defined by the insertion rules
+func insertionIndex(lines []string, rules *lineInFileRules) int {
+	if rules.insertBOF {
+		return 0
+	}
+
+	if rules.insertBefore != nil {
+		if matchIndex := lastMatchingLine(lines, rules.insertBefore); matchIndex >= 0 {
+			return matchIndex
+		}
+	} else if rules.insertAfter != nil {
+		if matchIndex := lastMatchingLine(lines, rules.insertAfter); matchIndex >= 0 {
+			return matchIndex + 1
+		}
+	}
+
+	return len(lines)
+}
+
+// expandBackRefs returns template with the references to the groups of the match of re in line replaced
+func expandBackRefs(re *regexp.Regexp, line string, template string) string {
+	match := re.FindStringSubmatchIndex(line)
+
+	return string(re.ExpandString(nil, template, line, match))
+}
+
+// lastMatchingLine returns the index of the last line matching re, or -1
+func lastMatchingLine(lines []string, re *regexp.Regexp) int {
+	for index := len(lines) - 1; index >= 0; index-- {
+		if re.MatchString(lines[index]) {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// splitFileLines splits the content of a file in lines, without line feeds
+func splitFileLines(content string) []string {
+	if content == "" {
+		return []string{}
+	}
+
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// joinFileLines joins lines in the content of a file, ending with a line feed
+func joinFileLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
 	}
 
-	return p.Exec(commands, config.ExecConfig)
+	return strings.Join(lines, "\n") + "\n"
 }
@@ -222,3 +222,158 @@ func ExampleParallexe_LineInFile_remove() {
 	fmt.Println(strings.Contains(string(content), "tata"))
 	// Output: false
 }
+
+func TestEditLineInFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		line     string
+		config   LineInFileConfig
+		expected string
+	}{
+		{"Append line", "a\nb\n", "c", LineInFileConfig{}, "a\nb\nc\n"},
+		{"Append line without final line feed", "a\nb", "c", LineInFileConfig{}, "a\nb\nc\n"},
+		{"Line already present", "a\nc\nb", "c", LineInFileConfig{}, "a\nc\nb"},
+		{"Line with slash", "a\n", "path=/usr/bin", LineInFileConfig{}, "a\npath=/usr/bin\n"},
+		{"Replace last matching line", "port=1\nport=2\nhost=x\n", "port=3", LineInFileConfig{Regexp: `^port=`}, "port=1\nport=3\nhost=x\n"},
+		{"Replace already done", "port=3\n", "port=3", LineInFileConfig{Regexp: `^port=`}, "port=3\n"},
+		{"Regexp without match", "a\n", "port=3", LineInFileConfig{Regexp: `^port=`}, "a\nport=3\n"},
+		{"Insert after", "[main]\na=1\n[other]\nb=2\n", "c=3", LineInFileConfig{InsertAfter: `^\[main\]`}, "[main]\nc=3\na=1\n[other]\nb=2\n"},
+		{"Insert after without match", "a\n", "c", LineInFileConfig{InsertAfter: `^\[main\]`}, "a\nc\n"},
+		{"Insert after EOF", "a\n", "c", LineInFileConfig{InsertAfter: InsertEOF}, "a\nc\n"},
+		{"Insert before", "a\n[other]\nb\n", "c", LineInFileConfig{InsertBefore: `^\[other\]`}, "a\nc\n[other]\nb\n"},
+		{"Insert before BOF", "a\n", "#!/bin/sh", LineInFileConfig{InsertBefore: InsertBOF}, "#!/bin/sh\na\n"},
+		{"Back references", "port = 22\n", "Port ${1}", LineInFileConfig{Regexp: `^port = (\d+)$`, BackRefs: true}, "Port 22\n"},
+		{"Back references without match", "a\n", "Port $1", LineInFileConfig{Regexp: `^port = (\d+)$`, BackRefs: true}, "a\n"},
+		{"Create empty file", "", "a", LineInFileConfig{}, "a\n"},
+		{"Remove line", "a\nb\na\n", "a", LineInFileConfig{Absent: true}, "b\n"},
+		{"Remove matching lines", "port=1\nhost=x\nport=2\n", "", LineInFileConfig{Absent: true, Regexp: `^port=`}, "host=x\n"},
+		{"Remove missing line", "a\nb", "c", LineInFileConfig{Absent: true}, "a\nb"},
+		{"Remove line used as regexp", "a.b\naxb\n", "a.b", LineInFileConfig{Absent: true}, "axb\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := compileLineInFileRules(&test.config)
+			if err != nil {
+				t.Fatalf("Error during rules compilation: %v", err)
+			}
+
+			result := editLineInFile(test.content, test.line, &test.config, rules)
+			if result != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestCompileLineInFileRules(t *testing.T) {
+	invalidConfigs := []LineInFileConfig{
+		{Regexp: "("},
+		{InsertAfter: "["},
+		{InsertBefore: "a(", Regexp: "b"},
+		{InsertAfter: "a", InsertBefore: "b"},
+		{BackRefs: true},
+	}
+
+	for _, config := range invalidConfigs {
+		if _, err := compileLineInFileRules(&config); err == nil {
+			t.Errorf("Expected %+v to be invalid", config)
+		}
+	}
+}
+
+func TestLineInFileChanged(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	dir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := fmt.Sprintf("%s/sshd_config", dir)
+	os.WriteFile(filePath, []byte("Port 22\nPermitRootLogin yes\n"), 0600)
+
+	config := &LineInFileConfig{
+		Regexp: `^PermitRootLogin `,
+		Backup: true,
+	}
+
+	response, err := pexe.LineInFile(filePath, "PermitRootLogin no", config)
+	if err != nil {
+		t.Fatalf("Error during LineInFile: %v", err)
+	}
+
+	if !response.HostResponses["localhost"].Changed {
+		t.Errorf("Expected file to be changed")
+	}
+	if !strings.Contains(response.HostResponses["localhost"].Diff, "-PermitRootLogin yes\n+PermitRootLogin no\n") {
+		t.Errorf("Diff is not correct, got %q", response.HostResponses["localhost"].Diff)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Error during file test reading: %v", err)
+	}
+	if string(content) != "Port 22\nPermitRootLogin no\n" {
+		t.Errorf("File content is not correct, got %q", string(content))
+	}
+
+	// Check the backup file contains the previous content
+	backupPath := strings.TrimSpace(response.HostResponses["localhost"].Stdout)
+	backupContent, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatalf("Error during backup file reading: %v", err)
+	}
+	if string(backupContent) != "Port 22\nPermitRootLogin yes\n" {
+		t.Errorf("Backup content is not correct, got %q", string(backupContent))
+	}
+
+	// Check file mode is kept
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Error during file test stat: %v", err)
+	}
+	if fileInfo.Mode().Perm() != 0600 {
+		t.Errorf("Expected file mode 0600, got %v", fileInfo.Mode().Perm())
+	}
+
+	// Second call must not change the file
+	response, err = pexe.LineInFile(filePath, "PermitRootLogin no", config)
+	if err != nil {
+		t.Fatalf("Error during LineInFile: %v", err)
+	}
+	if response.HostResponses["localhost"].Changed {
+		t.Errorf("Expected file not to be changed")
+	}
+}
+
+func TestLineInFileNoCreate(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	dir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := fmt.Sprintf("%s/missing.conf", dir)
+
+	_, err = pexe.LineInFile(filePath, "tata", &LineInFileConfig{NoCreate: true})
+	if err == nil {
+		t.Fatalf("LineInFile should fail on a file that does not exist with NoCreate")
+	}
+
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("Expected file not to be created, got %v", err)
+	}
+}
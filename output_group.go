@@ -0,0 +1,223 @@
+package parallexe
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hostNumberRegexp splits a host name between its prefix and its trailing number
+var hostNumberRegexp = regexp.MustCompile(`^(.*?)(\d+)$`)
+
+// OutputGroup contains the hosts sharing the same output
+type OutputGroup struct {
+	// Stdout, Stderr, Code and Error are the output of the first host of the group (before normalization)
+	Stdout string
+	Stderr string
+	Code   int
+	Error  string
+	// Hosts is sorted by name
+	Hosts []string
+	// Outlier is true if the group has fewer hosts than the largest group
+	Outlier bool
+}
+
+type GroupOutputsConfig struct {
+	// IgnoreStderr groups the hosts without comparing their Stderr and Error
+	IgnoreStderr bool
+	// IgnoreCode groups the hosts without comparing their exit code
+	IgnoreCode bool
+	// Normalize transforms Stdout and Stderr before they are compared (e.g. to remove timestamps or host names)
+	Normalize func(output string) string
+}
+
+// GroupOutputs groups the hosts having an identical output (Stdout, Stderr, exit code and error), as dshbak -c.
+// Groups are sorted by number of hosts (largest first), and hosts are sorted by name.
+// The groups smaller than the largest one are marked as outliers.
+func (r *CommandResponses) GroupOutputs(config *GroupOutputsConfig) []OutputGroup {
+	if config == nil {
+		config = &GroupOutputsConfig{}
+	}
+
+	normalize := func(output string) string {
+		if config.Normalize == nil {
+			return output
+		}
+		return config.Normalize(output)
+	}
+
+	groupIndexes := make(map[string]int)
+	outputGroups := make([]OutputGroup, 0)
+
+	for _, host := range r.SortedHosts() {
+		commandResponse := r.HostResponses[host]
+
+		errorMessage := ""
+		if commandResponse.Error != nil {
+			errorMessage = commandResponse.Error.Error()
+		}
+
+		key := []string{normalize(commandResponse.Stdout)}
+		if !config.IgnoreStderr {
+			key = append(key, normalize(commandResponse.Stderr), errorMessage)
+		}
+		if !config.IgnoreCode {
+			key = append(key, strconv.Itoa(commandResponse.Code))
+		}
+		// Quote each part, so that the key of different outputs can't be equal
+		groupKey := fmt.Sprintf("%q", key)
+
+		index, ok := groupIndexes[groupKey]
+		if !ok {
+			index = len(outputGroups)
+			groupIndexes[groupKey] = index
+			outputGroups = append(outputGroups, OutputGroup{
+				Stdout: commandResponse.Stdout,
+				Stderr: commandResponse.Stderr,
+				Code:   commandResponse.Code,
+				Error:  errorMessage,
+			})
+		}
+		outputGroups[index].Hosts = append(outputGroups[index].Hosts, host)
+	}
+
+	sort.SliceStable(outputGroups, func(i, j int) bool {
+		return len(outputGroups[i].Hosts) > len(outputGroups[j].Hosts)
+	})
+
+	for index := range outputGroups {
+		outputGroups[index].Outlier = len(outputGroups[index].Hosts) < len(outputGroups[0].Hosts)
+	}
+
+	return outputGroups
+}
+
+// FormatOutputGroups renders output groups as text, as dshbak -c: a header with the hosts of each group
+// (consecutive numbered hosts compacted as web[1-3]), followed by the output.
+// Stderr, exit code and error are printed when they are not empty.
+func FormatOutputGroups(outputGroups []OutputGroup) string {
+	var formatted strings.Builder
+	separator := strings.Repeat("-", 16) + "\n"
+
+	for _, outputGroup := range outputGroups {
+		formatted.WriteString(separator)
+		formatted.WriteString(CompactHosts(outputGroup.Hosts))
+		fmt.Fprintf(&formatted, " (%d)", len(outputGroup.Hosts))
+		if outputGroup.Outlier {
+			formatted.WriteString(" [outlier]")
+		}
+		formatted.WriteString("\n")
+		formatted.WriteString(separator)
+
+		writeOutput(&formatted, outputGroup.Stdout)
+		if outputGroup.Stderr != "" {
+			formatted.WriteString("stderr:\n")
+			writeOutput(&formatted, outputGroup.Stderr)
+		}
+		if outputGroup.Code != 0 {
+			fmt.Fprintf(&formatted, "exit code: %d\n", outputGroup.Code)
+		}
+		if outputGroup.Error != "" {
+			fmt.Fprintf(&formatted, "error: %s\n", outputGroup.Error)
+		}
+	}
+
+	return formatted.String()
+}
+
+// writeOutput writes output to builder, followed by a newline if it does not end with one
+func writeOutput(builder *strings.Builder, output string) {
+	builder.WriteString(output)
+	if output != "" && !strings.HasSuffix(output, "\n") {
+		builder.WriteString("\n")
+	}
+}
+
+// CompactHosts returns hosts as a comma separated list, where the hosts with the same prefix and a trailing number
+// are compacted as ranges (e.g. web1, web2, web3, web5 and db1 give db1,web[1-3,5]).
+// Numbers with leading zeros are only compacted with numbers of the same width.
+func CompactHosts(hosts []string) string {
+	type hostRange struct {
+		prefix  string
+		numbers []int
+		width   int
+	}
+
+	ranges := make([]*hostRange, 0)
+	rangeIndexes := make(map[string]*hostRange)
+
+	sortedHosts := append([]string{}, hosts...)
+	sort.Strings(sortedHosts)
+
+	for _, host := range sortedHosts {
+		match := hostNumberRegexp.FindStringSubmatch(host)
+		if match == nil {
+			ranges = append(ranges, &hostRange{prefix: host})
+			continue
+		}
+
+		number, err := strconv.Atoi(match[2])
+		if err != nil {
+			ranges = append(ranges, &hostRange{prefix: host})
+			continue
+		}
+
+		width := 0
+		if len(match[2]) > 1 && match[2][0] == '0' {
+			width = len(match[2])
+		}
+
+		key := fmt.Sprintf("%s/%d", match[1], width)
+		if existing, ok := rangeIndexes[key]; ok {
+			existing.numbers = append(existing.numbers, number)
+			continue
+		}
+
+		r := &hostRange{prefix: match[1], numbers: []int{number}, width: width}
+		rangeIndexes[key] = r
+		ranges = append(ranges, r)
+	}
+
+	compacted := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		switch len(r.numbers) {
+		case 0:
+			compacted = append(compacted, r.prefix)
+		case 1:
+			compacted = append(compacted, r.prefix+formatHostNumber(r.numbers[0], r.width))
+		default:
+			compacted = append(compacted, fmt.Sprintf("%s[%s]", r.prefix, formatHostNumbers(r.numbers, r.width)))
+		}
+	}
+
+	return strings.Join(compacted, ",")
+}
+
+// formatHostNumbers returns numbers as ranges of consecutive numbers (e.g. 1-3,5)
+func formatHostNumbers(numbers []int, width int) string {
+	sort.Ints(numbers)
+
+	parts := make([]string, 0)
+	for start := 0; start < len(numbers); {
+		end := start
+		for end+1 < len(numbers) && numbers[end+1] <= numbers[end]+1 {
+			end++
+		}
+
+		if numbers[start] == numbers[end] {
+			parts = append(parts, formatHostNumber(numbers[start], width))
+		} else {
+			parts = append(parts, formatHostNumber(numbers[start], width)+"-"+formatHostNumber(numbers[end], width))
+		}
+		start = end + 1
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// formatHostNumber returns number padded with zeros to width
+func formatHostNumber(number int, width int) string {
+	return fmt.Sprintf("%0*d", width, number)
+}
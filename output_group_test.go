@@ -0,0 +1,81 @@
+package parallexe
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestGroupOutputs(t *testing.T) {
+	responses := &CommandResponses{HostResponses: map[string]*CommandResponse{
+		"web1": {Stdout: "ubuntu 22.04\n", Success: true},
+		"web2": {Stdout: "ubuntu 22.04\n", Success: true},
+		"web3": {Stdout: "ubuntu 22.04\n", Success: true},
+		"db1":  {Stdout: "debian 12\n", Success: true},
+		"db2":  {Stdout: "ubuntu 22.04\n", Stderr: "warning\n", Code: 1},
+		"db3":  {Error: errors.New("connection refused"), Code: -1},
+	}}
+
+	outputGroups := responses.GroupOutputs(nil)
+	if len(outputGroups) != 4 {
+		t.Fatalf("Expected 4 groups, got %+v", outputGroups)
+	}
+
+	if outputGroups[0].Stdout != "ubuntu 22.04\n" || len(outputGroups[0].Hosts) != 3 || outputGroups[0].Outlier {
+		t.Errorf("Expected web hosts in the first group, got %+v", outputGroups[0])
+	}
+	// Groups of the same size are sorted by host name
+	if outputGroups[1].Hosts[0] != "db1" || !outputGroups[1].Outlier || outputGroups[3].Error != "connection refused" {
+		t.Errorf("Expected outliers sorted by host, got %+v", outputGroups[1:])
+	}
+
+	t.Run("Ignore stderr and code", func(t *testing.T) {
+		outputGroups := responses.GroupOutputs(&GroupOutputsConfig{IgnoreStderr: true, IgnoreCode: true})
+
+		if len(outputGroups) != 3 || len(outputGroups[0].Hosts) != 4 {
+			t.Errorf("Expected db2 to be grouped with web hosts, got %+v", outputGroups)
+		}
+	})
+
+	t.Run("Normalized outputs", func(t *testing.T) {
+		responses := &CommandResponses{HostResponses: map[string]*CommandResponse{
+			"web1": {Stdout: "up 3 days, load 0.10\n"},
+			"web2": {Stdout: "up 5 days, load 0.20\n"},
+		}}
+		numbers := regexp.MustCompile(`[0-9.]+`)
+
+		outputGroups := responses.GroupOutputs(&GroupOutputsConfig{Normalize: func(output string) string {
+			return numbers.ReplaceAllString(output, "N")
+		}})
+		if len(outputGroups) != 1 || outputGroups[0].Stdout != "up 3 days, load 0.10\n" {
+			t.Errorf("Expected normalized outputs to be grouped, got %+v", outputGroups)
+		}
+	})
+
+	t.Run("Format", func(t *testing.T) {
+		expected := "----------------\nweb[1-3] (3)\n----------------\nubuntu 22.04\n" +
+			"----------------\ndb1 (1) [outlier]\n----------------\ndebian 12\n" +
+			"----------------\ndb2 (1) [outlier]\n----------------\nubuntu 22.04\nstderr:\nwarning\nexit code: 1\n" +
+			"----------------\ndb3 (1) [outlier]\n----------------\nexit code: -1\nerror: connection refused\n"
+
+		if formatted := FormatOutputGroups(outputGroups); formatted != expected {
+			t.Errorf("Wrong format, got %q", formatted)
+		}
+	})
+}
+
+func TestCompactHosts(t *testing.T) {
+	hostLists := map[string][]string{
+		"db1,web[1-3,5,10]":        {"web10", "web2", "web1", "db1", "web3", "web5"},
+		"10.0.0.[1-2]":             {"10.0.0.2", "10.0.0.1"},
+		"localhost,node[01-02,09]": {"node09", "localhost", "node01", "node02"},
+		"node01,node1":             {"node1", "node01"},
+		"":                         {},
+	}
+
+	for expected, hosts := range hostLists {
+		if compacted := CompactHosts(hosts); compacted != expected {
+			t.Errorf("Expected %q for %v, got %q", expected, hosts, compacted)
+		}
+	}
+}
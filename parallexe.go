@@ -22,6 +22,19 @@ type SshConfig struct {
 type HostConnection struct {
 	HostConfig HostConfig
 	Client     *ssh.Client
+
+	// state is shared by the copies of the HostConnection
+	state *hostState
+	// secrets contains the secret values rendered for the host by the running operation
+	secrets *secretScope
+}
+
+// hostState contains the data cached for a host
+type hostState struct {
+	m     sync.Mutex
+	facts *Facts
+	// dynamicGroups contains the groups added by GroupByFacts and GroupByCommand
+	dynamicGroups []string
 }
 
 type HostConfig struct {
@@ -32,28 +45,37 @@ type HostConfig struct {
 
 type Parallexe struct {
 	HostConnections []HostConnection
+	m               sync.Mutex
 }
 
 // New creates a new Parallexe client with a list of HostConfig
 // It returns an error if at least one host is not reachable
 // If Host is localhost or 127.0.0.1, it will create a HostConnection with Client nil
+// HostConnections are kept in the same order as configs.
 func New(configs []HostConfig) (*Parallexe, error) {
 	var wg sync.WaitGroup
 	wg.Add(len(configs))
 
-	var p Parallexe
-
+	var m sync.Mutex
+	hostConnections := make([]HostConnection, len(configs))
 	hostErrors := make([]error, 0)
 
-	for _, config := range configs {
+	for index, config := range configs {
+		loopIndex := index
 		loopConfig := config
 		go func() {
 			defer wg.Done()
 
-			err := p.AddHost(loopConfig)
+			hostConnection, err := newHostConnection(loopConfig)
+
+			m.Lock()
+			defer m.Unlock()
+
 			if err != nil {
 				hostErrors = append(hostErrors, err)
+				return
 			}
+			hostConnections[loopIndex] = hostConnection
 		}()
 	}
 
@@ -63,34 +85,47 @@ func New(configs []HostConfig) (*Parallexe, error) {
 		return nil, fmt.Errorf("error while creating Parallexe client: %v", hostErrors)
 	}
 
-	return &p, nil
+	return &Parallexe{HostConnections: hostConnections}, nil
 }
 
 // AddHost adds a new host to the Parallexe client
 func (p *Parallexe) AddHost(hostConfig HostConfig) error {
-	// Skip createClient if host is localhost
+	hostConnection, err := newHostConnection(hostConfig)
+	if err != nil {
+		return err
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.HostConnections = append(p.HostConnections, hostConnection)
+
+	return nil
+}
+
+// newHostConnection creates the HostConnection of a host.
+// Skip createClient if host is localhost
+func newHostConnection(hostConfig HostConfig) (HostConnection, error) {
 	for _, localHostValue := range localHostValues {
 		if hostConfig.Host == localHostValue {
-			p.HostConnections = append(p.HostConnections, HostConnection{
+			return HostConnection{
 				HostConfig: hostConfig,
 				Client:     nil,
-			})
-
-			return nil
+				state:      &hostState{},
+			}, nil
 		}
 	}
 
 	newClient, err := createClient(hostConfig.Host, hostConfig.SshConfig)
 	if err != nil {
-		return err
+		return HostConnection{}, err
 	}
 
-	p.HostConnections = append(p.HostConnections, HostConnection{
+	return HostConnection{
 		HostConfig: hostConfig,
 		Client:     newClient,
-	})
-
-	return nil
+		state:      &hostState{},
+	}, nil
 }
 
 // Close closes all SSH connections in all HostConnections
@@ -0,0 +1,434 @@
+package parallexe
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Playbook is a declarative list of tasks run on the hosts, loaded from a YAML or JSON file with LoadPlaybook.
+// Each task runs one operation (exec, send or line_in_file) on the hosts which did not fail a previous task.
+type Playbook struct {
+	Name string `yaml:"name"`
+	// Hosts and Groups select the hosts of the playbook, as in ExecConfig. If both are empty, all hosts are selected.
+	Hosts  []string `yaml:"hosts"`
+	Groups []string `yaml:"groups"`
+	// Defaults, Vars, GroupVars and HostVars are the variables of the templates, as in ExecVariables
+	Defaults  KeyValueVariable            `yaml:"defaults"`
+	Vars      KeyValueVariable            `yaml:"vars"`
+	GroupVars map[string]KeyValueVariable `yaml:"group_vars"`
+	HostVars  map[string]KeyValueVariable `yaml:"host_vars"`
+	// GroupPriority and DeepMerge define how the variables are merged, as in ExecVariables
+	GroupPriority map[string]int `yaml:"group_priority"`
+	DeepMerge     bool           `yaml:"deep_merge"`
+	Tasks         []PlaybookTask `yaml:"tasks"`
+	// OnFailure tasks are run on the hosts where a task failed, OnSuccess tasks on the other hosts,
+	// then Always tasks on all hosts, as the handlers of a Sequence
+	OnFailure []PlaybookTask `yaml:"on_failure"`
+	OnSuccess []PlaybookTask `yaml:"on_success"`
+	Always    []PlaybookTask `yaml:"always"`
+
+	// dir is the directory of the playbook file. Relative sources of send tasks are read from it.
+	dir string
+}
+
+// PlaybookTask is a named operation of a Playbook. Exactly one of Exec, Send and LineInFile must be set.
+type PlaybookTask struct {
+	Name string `yaml:"name"`
+	// Hosts and Groups restrict the hosts of the task among the ones of the playbook
+	Hosts  []string `yaml:"hosts"`
+	Groups []string `yaml:"groups"`
+	// When is a template condition evaluated per host, as Step.When
+	When string `yaml:"when"`
+	// Register stores the result of the task on each host as a RegisteredResult variable, as Step.Register
+	Register string `yaml:"register"`
+
+	// Exec is a command, rendered as a template for each host
+	Exec       string              `yaml:"exec"`
+	Send       *PlaybookSend       `yaml:"send"`
+	LineInFile *PlaybookLineInFile `yaml:"line_in_file"`
+}
+
+// PlaybookSend contains the options of a send task, as SendConfig
+type PlaybookSend struct {
+	Src      string `yaml:"src"`
+	Dest     string `yaml:"dest"`
+	Template bool   `yaml:"template"`
+	Owner    string `yaml:"owner"`
+	Group    string `yaml:"group"`
+	// Mode is the octal mode of the destination file (e.g. "0644")
+	Mode          string `yaml:"mode"`
+	CreateParents bool   `yaml:"create_parents"`
+}
+
+// PlaybookLineInFile contains the options of a line_in_file task, as LineInFileConfig
+type PlaybookLineInFile struct {
+	Path         string `yaml:"path"`
+	Line         string `yaml:"line"`
+	Regexp       string `yaml:"regexp"`
+	Absent       bool   `yaml:"absent"`
+	InsertAfter  string `yaml:"insert_after"`
+	InsertBefore string `yaml:"insert_before"`
+	BackRefs     bool   `yaml:"backrefs"`
+	NoCreate     bool   `yaml:"no_create"`
+	Backup       bool   `yaml:"backup"`
+}
+
+// PlaybookReport contains the result of each task of a playbook, followed by the results of its handlers
+type PlaybookReport struct {
+	Name  string
+	Tasks []*TaskReport
+}
+
+// TaskReport contains the status and the response of a task for each host of the playbook
+type TaskReport struct {
+	Name string
+	// Handler is empty for the tasks, and identifies the on_failure, on_success and always tasks
+	Handler       Handler
+	HostStatuses  map[string]CommandStatus
+	HostResponses map[string]*CommandResponse
+}
+
+// LoadPlaybook reads and validates a YAML or JSON playbook file.
+// The sources of send tasks are read relatively to the directory of the playbook.
+func LoadPlaybook(path string) (*Playbook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't read playbook %s: %v", path, err)
+	}
+
+	playbook, err := ParsePlaybook(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid playbook %s: %v", path, err)
+	}
+	playbook.dir = filepath.Dir(path)
+
+	return playbook, nil
+}
+
+// ParsePlaybook parses and validates a YAML or JSON playbook. Unknown fields are rejected.
+func ParsePlaybook(data []byte) (*Playbook, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	playbook := &Playbook{}
+	if err := decoder.Decode(playbook); err != nil {
+		return nil, fmt.Errorf("can't parse playbook: %v", err)
+	}
+
+	if err := playbook.validate(); err != nil {
+		return nil, err
+	}
+
+	return playbook, nil
+}
+
+// validate checks that each task has exactly one valid operation and a valid condition
+func (playbook *Playbook) validate() error {
+	for _, tasks := range [][]PlaybookTask{playbook.Tasks, playbook.OnFailure, playbook.OnSuccess, playbook.Always} {
+		for index, task := range tasks {
+			if err := task.validate(); err != nil {
+				return fmt.Errorf("task %d (%s): %v", index+1, task.name(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validate checks the operation and the condition of a task
+func (task PlaybookTask) validate() error {
+	operations := 0
+	if task.Exec != "" {
+		operations++
+	}
+	if task.Send != nil {
+		operations++
+		if task.Send.Src == "" || task.Send.Dest == "" {
+			return fmt.Errorf("send requires src and dest")
+		}
+		if _, err := parsePlaybookMode(task.Send.Mode); err != nil {
+			return err
+		}
+	}
+	if task.LineInFile != nil {
+		operations++
+		if task.LineInFile.Path == "" {
+			return fmt.Errorf("line_in_file requires path")
+		}
+	}
+
+	if operations != 1 {
+		return fmt.Errorf("exactly one of exec, send and line_in_file is required")
+	}
+
+	_, err := parseWhen(task.When)
+
+	return err
+}
+
+// name returns the name of a task, or a description of its operation if it has none
+func (task PlaybookTask) name() string {
+	switch {
+	case task.Name != "":
+		return task.Name
+	case task.Exec != "":
+		return "exec: " + task.Exec
+	case task.Send != nil:
+		return "send: " + task.Send.Dest
+	case task.LineInFile != nil:
+		return "line_in_file: " + task.LineInFile.Path
+	}
+
+	return ""
+}
+
+// parsePlaybookMode parses an octal file mode. An empty mode returns 0.
+func parsePlaybookMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+
+	fileMode := os.FileMode(value & 0777)
+	if value&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if value&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if value&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+
+	return fileMode, nil
+}
+
+// RunPlaybook runs the tasks of a playbook in order on its hosts, among the ones filtered by execConfig.
+// execConfig also defines how the operations are run (become, environment, ...). Its Stdin and Pty are ignored,
+// and only the ExtraVariables, Secrets and Sensitive of its ExecVariables are used.
+// A host stops at its first failed task, while the others continue. The handlers are then run as in RunSequence.
+// The returned report contains the status and the response of each task per host.
+func (p *Parallexe) RunPlaybook(playbook *Playbook, execConfig *ExecConfig) (*PlaybookReport, error) {
+	if err := playbook.validate(); err != nil {
+		return nil, err
+	}
+
+	hosts := getFilteredHosts(getFilteredHosts(p.HostConnections, execConfig), &ExecConfig{Hosts: playbook.Hosts, Groups: playbook.Groups})
+
+	taskConfig := ExecConfig{}
+	if execConfig != nil {
+		taskConfig = *execConfig
+	}
+	taskConfig.Stdin, taskConfig.HostStdin, taskConfig.Pty = nil, nil, false
+	taskConfig.CompileTemplate = true
+	taskConfig.ExecVariables = &ExecVariables{
+		Defaults:       playbook.Defaults,
+		Variables:      playbook.Vars,
+		GroupVariables: playbook.GroupVars,
+		HostVariables:  playbook.HostVars,
+		GroupPriority:  playbook.GroupPriority,
+		DeepMerge:      playbook.DeepMerge,
+	}
+	// The extra variables of execConfig override the ones of the playbook
+	if execConfig != nil && execConfig.ExecVariables != nil {
+		taskConfig.ExecVariables.ExtraVariables = execConfig.ExecVariables.ExtraVariables
+		taskConfig.ExecVariables.Secrets = execConfig.ExecVariables.Secrets
+		taskConfig.ExecVariables.Sensitive = execConfig.ExecVariables.Sensitive
+	}
+
+	state := newSequenceState()
+	taskConfig.ExecVariables.registered = state.registered
+	report := &PlaybookReport{Name: playbook.Name}
+
+	if err := p.runPlaybookTasks(report, playbook, playbook.Tasks, "", hosts, hosts, &taskConfig, state); err != nil {
+		return report, err
+	}
+
+	// Split hosts between the ones which failed a task and the others
+	failedHosts := make([]HostConnection, 0)
+	succeededHosts := make([]HostConnection, 0)
+	for _, host := range hosts {
+		if state.failedHosts[host.HostConfig.Host] {
+			failedHosts = append(failedHosts, host)
+		} else {
+			succeededHosts = append(succeededHosts, host)
+		}
+	}
+
+	if err := p.runPlaybookTasks(report, playbook, playbook.OnFailure, HandlerOnFailure, hosts, failedHosts, &taskConfig, state); err != nil {
+		return report, err
+	}
+	if err := p.runPlaybookTasks(report, playbook, playbook.OnSuccess, HandlerOnSuccess, hosts, succeededHosts, &taskConfig, state); err != nil {
+		return report, err
+	}
+	if err := p.runPlaybookTasks(report, playbook, playbook.Always, HandlerAlways, hosts, hosts, &taskConfig, state); err != nil {
+		return report, err
+	}
+
+	errorHosts := make([]string, 0)
+	for _, host := range hosts {
+		if state.failedHosts[host.HostConfig.Host] {
+			errorHosts = append(errorHosts, host.HostConfig.Host)
+		}
+	}
+
+	var commandError error
+	if len(errorHosts) > 0 {
+		commandError = fmt.Errorf("error on hosts: %v", errorHosts)
+	}
+
+	return report, commandError
+}
+
+// runPlaybookTasks runs tasks in order on hosts, and adds a TaskReport per task tagged with handler to report.
+// Statuses are reported for all reportHosts. A host stops at its first failed task, and is added to the failed hosts of state.
+func (p *Parallexe) runPlaybookTasks(report *PlaybookReport, playbook *Playbook, tasks []PlaybookTask, handler Handler, reportHosts []HostConnection, hosts []HostConnection, execConfig *ExecConfig, state *sequenceState) error {
+	activeHosts := hosts
+	for _, task := range tasks {
+		taskReport := &TaskReport{
+			Name:          task.name(),
+			Handler:       handler,
+			HostStatuses:  make(map[string]CommandStatus),
+			HostResponses: make(map[string]*CommandResponse),
+		}
+		for _, host := range reportHosts {
+			taskReport.HostStatuses[host.HostConfig.Host] = CommandStatusSkip
+		}
+		report.Tasks = append(report.Tasks, taskReport)
+
+		when, err := parseWhen(task.When)
+		if err != nil {
+			return err
+		}
+		taskHosts := getFilteredHosts(activeHosts, &ExecConfig{Hosts: task.Hosts, Groups: task.Groups})
+		runHosts := playbookTaskHosts(taskReport, when, taskHosts, execConfig, state)
+
+		if len(runHosts) > 0 {
+			hostNames := make([]string, len(runHosts))
+			for index, host := range runHosts {
+				hostNames[index] = host.HostConfig.Host
+			}
+
+			runConfig := *execConfig
+			runConfig.Hosts, runConfig.Groups = hostNames, nil
+
+			responses, err := p.runPlaybookOperation(playbook, task, &runConfig)
+
+			for _, host := range runHosts {
+				name := host.HostConfig.Host
+
+				var response *CommandResponse
+				if responses != nil {
+					response = responses.HostResponses[name]
+				}
+				if response == nil {
+					responseError := err
+					if responseError == nil {
+						responseError = fmt.Errorf("no response for host %s", name)
+					}
+					response = newErrorResponse(responseError)
+				}
+
+				if task.Register != "" {
+					state.registered.register(name, task.Register, newRegisteredResult(response))
+				}
+
+				state.previous[name] = response
+				taskReport.HostResponses[name] = response
+				taskReport.HostStatuses[name] = CommandStatusDone
+				if response.Error != nil || response.Stderr != "" {
+					taskReport.HostStatuses[name] = CommandStatusFailed
+					state.failedHosts[name] = true
+				}
+			}
+		}
+
+		nextHosts := make([]HostConnection, 0, len(activeHosts))
+		for _, host := range activeHosts {
+			if taskReport.HostStatuses[host.HostConfig.Host] != CommandStatusFailed {
+				nextHosts = append(nextHosts, host)
+			}
+		}
+		activeHosts = nextHosts
+	}
+
+	return nil
+}
+
+// playbookTaskHosts returns the hosts where the condition of a task is met.
+// The other hosts are reported with the status CommandStatusConditionSkip, or CommandStatusFailed if the condition can't be evaluated.
+func playbookTaskHosts(taskReport *TaskReport, when *template.Template, hosts []HostConnection, execConfig *ExecConfig, state *sequenceState) []HostConnection {
+	runHosts := make([]HostConnection, 0, len(hosts))
+
+	for _, host := range hosts {
+		name := host.HostConfig.Host
+
+		run, err := state.evaluateWhen(when, host, execConfig)
+		if err != nil {
+			taskReport.HostResponses[name] = newErrorResponse(err)
+			taskReport.HostStatuses[name] = CommandStatusFailed
+			state.failedHosts[name] = true
+			continue
+		}
+
+		if !run {
+			taskReport.HostStatuses[name] = CommandStatusConditionSkip
+			continue
+		}
+
+		runHosts = append(runHosts, host)
+	}
+
+	return runHosts
+}
+
+// runPlaybookOperation runs the operation of a task on the hosts of execConfig
+func (p *Parallexe) runPlaybookOperation(playbook *Playbook, task PlaybookTask, execConfig *ExecConfig) (*CommandResponses, error) {
+	switch {
+	case task.Exec != "":
+		return p.Exec(task.Exec, execConfig)
+	case task.Send != nil:
+		mode, err := parsePlaybookMode(task.Send.Mode)
+		if err != nil {
+			return nil, err
+		}
+
+		src := task.Send.Src
+		if playbook.dir != "" && !filepath.IsAbs(src) {
+			src = filepath.Join(playbook.dir, src)
+		}
+
+		return p.Send(src, task.Send.Dest, &SendConfig{
+			ExecConfig:      execConfig,
+			CompileTemplate: task.Send.Template,
+			ExecVariables:   execConfig.ExecVariables,
+			Owner:           task.Send.Owner,
+			Group:           task.Send.Group,
+			Mode:            mode,
+			CreateParents:   task.Send.CreateParents,
+		})
+	case task.LineInFile != nil:
+		return p.LineInFile(task.LineInFile.Path, task.LineInFile.Line, &LineInFileConfig{
+			ExecConfig:   execConfig,
+			Absent:       task.LineInFile.Absent,
+			Regexp:       task.LineInFile.Regexp,
+			InsertAfter:  task.LineInFile.InsertAfter,
+			InsertBefore: task.LineInFile.InsertBefore,
+			BackRefs:     task.LineInFile.BackRefs,
+			NoCreate:     task.LineInFile.NoCreate,
+			Backup:       task.LineInFile.Backup,
+		})
+	}
+
+	return nil, fmt.Errorf("task %s has no operation", task.name())
+}
@@ -0,0 +1,196 @@
+package parallexe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePlaybook(t *testing.T) {
+	t.Run("YAML playbook", func(t *testing.T) {
+		playbook, err := ParsePlaybook([]byte(`
+name: deploy
+groups: [web]
+vars:
+  port: 8080
+tasks:
+  - name: Check version
+    exec: cat /etc/version
+    register: version
+  - send:
+      src: app.conf.tpl
+      dest: /etc/app.conf
+      template: true
+      mode: "0640"
+    when: ne .version.Stdout "2"
+on_failure:
+  - exec: echo failed
+`))
+		if err != nil {
+			t.Fatalf("Error during playbook parsing: %v", err)
+		}
+
+		if playbook.Name != "deploy" || len(playbook.Tasks) != 2 || len(playbook.OnFailure) != 1 {
+			t.Fatalf("Playbook is not correct, got %+v", playbook)
+		}
+		if playbook.Tasks[1].Send.Dest != "/etc/app.conf" || playbook.Tasks[1].name() != "send: /etc/app.conf" {
+			t.Errorf("Send task is not correct, got %+v", playbook.Tasks[1].Send)
+		}
+		if playbook.Vars["port"] != 8080 {
+			t.Errorf("Expected port variable, got %v", playbook.Vars["port"])
+		}
+	})
+
+	t.Run("JSON playbook", func(t *testing.T) {
+		playbook, err := ParsePlaybook([]byte(`{"name": "json", "tasks": [{"exec": "uptime"}]}`))
+		if err != nil {
+			t.Fatalf("Error during playbook parsing: %v", err)
+		}
+
+		if playbook.Tasks[0].Exec != "uptime" {
+			t.Errorf("Expected exec task, got %+v", playbook.Tasks[0])
+		}
+	})
+
+	invalidPlaybooks := map[string]string{
+		"Unknown field":     "tasks:\n  - exec: uptime\n    become_user: root\n",
+		"No operation":      "tasks:\n  - name: nothing\n",
+		"Two operations":    "tasks:\n  - exec: uptime\n    line_in_file: {path: /etc/hosts}\n",
+		"Invalid mode":      "tasks:\n  - send: {src: a, dest: b, mode: \"999\"}\n",
+		"Invalid condition": "tasks:\n  - exec: uptime\n    when: eq .Host )\n",
+	}
+
+	for name, playbook := range invalidPlaybooks {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParsePlaybook([]byte(playbook)); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestRunPlaybook(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost", Groups: []string{"web"}}, {Host: "127.0.0.1", Groups: []string{"db"}}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	dir, err := os.MkdirTemp("", "parallexe-playbook")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.WriteFile(filepath.Join(dir, "app.conf.tpl"), []byte("port={{ .port }}\nrelease={{ .release.Stdout }}"), 0644); err != nil {
+		t.Fatalf("Error during template creation: %v", err)
+	}
+
+	playbookPath := filepath.Join(dir, "playbook.yml")
+	playbookContent := strings.ReplaceAll(`
+name: deploy
+vars:
+  port: 8080
+group_vars:
+  db:
+    port: 5432
+tasks:
+  - name: Release
+    exec: echo 1.2
+    register: release
+  - name: Configuration
+    send:
+      src: app.conf.tpl
+      dest: DIR/app.conf
+      template: true
+    groups: [web]
+  - name: Enable debug
+    line_in_file:
+      path: DIR/app.conf
+      line: debug=true
+    hosts: [localhost]
+  - name: Fail on db
+    exec: echo failed >&2
+    when: eq .port 5432
+  - name: Restart
+    exec: echo restarted
+on_failure:
+  - exec: echo rollback
+on_success:
+  - exec: echo done
+`, "DIR", dir)
+	if err := os.WriteFile(playbookPath, []byte(playbookContent), 0644); err != nil {
+		t.Fatalf("Error during playbook creation: %v", err)
+	}
+
+	playbook, err := LoadPlaybook(playbookPath)
+	if err != nil {
+		t.Fatalf("Error during playbook loading: %v", err)
+	}
+
+	report, err := pexe.RunPlaybook(playbook, nil)
+	if err == nil || err.Error() != "error on hosts: [127.0.0.1]" {
+		t.Fatalf("Expected an error on 127.0.0.1, got %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(dir, "app.conf"))
+	if string(content) != "port=8080\nrelease=1.2\ndebug=true\n" {
+		t.Errorf("Wrong configuration, got %q", string(content))
+	}
+
+	if len(report.Tasks) != 7 {
+		t.Fatalf("Expected 7 task reports, got %d", len(report.Tasks))
+	}
+
+	expected := map[string][]CommandStatus{
+		"localhost": {CommandStatusDone, CommandStatusDone, CommandStatusDone, CommandStatusConditionSkip, CommandStatusDone, CommandStatusSkip, CommandStatusDone},
+		"127.0.0.1": {CommandStatusDone, CommandStatusSkip, CommandStatusSkip, CommandStatusFailed, CommandStatusSkip, CommandStatusDone, CommandStatusSkip},
+	}
+	for host, statuses := range expected {
+		for index, status := range statuses {
+			if report.Tasks[index].HostStatuses[host] != status {
+				t.Errorf("Expected status %s for task %s on %s, got %s", status, report.Tasks[index].Name, host, report.Tasks[index].HostStatuses[host])
+			}
+		}
+	}
+
+	if report.Tasks[5].Handler != HandlerOnFailure || report.Tasks[5].HostResponses["127.0.0.1"].Stdout != "rollback\n" {
+		t.Errorf("Expected on_failure handler on 127.0.0.1, got %+v", report.Tasks[5])
+	}
+}
+
+func TestRunPlaybookTasks(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	t.Run("Invalid condition", func(t *testing.T) {
+		report := &PlaybookReport{}
+		err := pexe.runPlaybookTasks(report, &Playbook{}, []PlaybookTask{{Exec: "true", When: "{{"}}, "", pexe.HostConnections, pexe.HostConnections, &ExecConfig{}, newSequenceState())
+		if err == nil {
+			t.Fatalf("Expected a condition parsing error")
+		}
+	})
+
+	t.Run("Operation without response", func(t *testing.T) {
+		report := &PlaybookReport{}
+		state := newSequenceState()
+		err := pexe.runPlaybookTasks(report, &Playbook{}, []PlaybookTask{{Name: "empty"}, {Exec: "echo next"}}, "", pexe.HostConnections, pexe.HostConnections, &ExecConfig{}, state)
+		if err != nil {
+			t.Fatalf("Error during runPlaybookTasks: %v", err)
+		}
+
+		if report.Tasks[0].HostStatuses["localhost"] != CommandStatusFailed || !state.failedHosts["localhost"] {
+			t.Fatalf("Expected the host to fail, got %s", report.Tasks[0].HostStatuses["localhost"])
+		}
+		if response := report.Tasks[0].HostResponses["localhost"]; response.Error == nil || response.Error.Error() != "task empty has no operation" {
+			t.Errorf("Expected the operation error, got %v", response.Error)
+		}
+		if report.Tasks[1].HostStatuses["localhost"] != CommandStatusSkip {
+			t.Errorf("Expected the next task to be skipped, got %s", report.Tasks[1].HostStatuses["localhost"])
+		}
+	})
+}
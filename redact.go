@@ -0,0 +1,141 @@
+package parallexe
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// DefaultRedactReplacement replaces the redacted values when RedactConfig.Replacement is empty
+const DefaultRedactReplacement = "[REDACTED]"
+
+// RedactConfig defines the sensitive values replaced in the responses (Stdout, Stderr, Diff, Error and Command) before they are returned.
+// The SecretRef values and the ExecVariables.Sensitive variables rendered for a host by an operation are always redacted
+// from its responses to this operation, as ExecConfig.BecomePassword.
+type RedactConfig struct {
+	// Secrets contains literal values to redact
+	Secrets []string
+	// Patterns contains regular expressions whose matches are redacted.
+	// If a pattern has capture groups, only the groups are redacted (e.g. `password=(\S+)` keeps "password=").
+	Patterns []*regexp.Regexp
+	// Replacement replaces the redacted values. Default is DefaultRedactReplacement.
+	Replacement string
+}
+
+// redactConfig returns the RedactConfig of execConfig, with the become password added to its secrets, or nil
+func redactConfig(execConfig *ExecConfig) *RedactConfig {
+	if execConfig == nil {
+		return nil
+	}
+
+	if execConfig.BecomePassword == "" {
+		return execConfig.Redact
+	}
+
+	config := RedactConfig{}
+	if execConfig.Redact != nil {
+		config = *execConfig.Redact
+	}
+	config.Secrets = append(slices.Clone(config.Secrets), execConfig.BecomePassword)
+
+	return &config
+}
+
+// redactResponse replaces in commandResponse the secrets rendered for the host by the running operation and the values defined by config
+func (hostConnection HostConnection) redactResponse(commandResponse *CommandResponse, config *RedactConfig) *CommandResponse {
+	redact := hostConnection.redactor(config)
+	if redact == nil || commandResponse == nil {
+		return commandResponse
+	}
+
+	commandResponse.Stdout = redact(commandResponse.Stdout)
+	commandResponse.Stderr = redact(commandResponse.Stderr)
+	commandResponse.Diff = redact(commandResponse.Diff)
+	commandResponse.Command = redact(commandResponse.Command)
+	if commandResponse.Error != nil {
+		if message := redact(commandResponse.Error.Error()); message != commandResponse.Error.Error() {
+			// The original error is not wrapped, as it would still be reachable with errors.Unwrap
+			commandResponse.Error = errors.New(message)
+		}
+	}
+
+	return commandResponse
+}
+
+// redactor returns a function redacting the secrets rendered for the host by the running operation and the values defined by config,
+// or nil if there is nothing to redact
+func (hostConnection HostConnection) redactor(config *RedactConfig) func(s string) string {
+	secrets := hostConnection.renderedSecrets()
+
+	replacement := DefaultRedactReplacement
+	var patterns []*regexp.Regexp
+	if config != nil {
+		for _, secret := range config.Secrets {
+			if secret != "" {
+				secrets = append(secrets, secret)
+			}
+		}
+		patterns = config.Patterns
+		if config.Replacement != "" {
+			replacement = config.Replacement
+		}
+	}
+
+	if len(secrets) == 0 && len(patterns) == 0 {
+		return nil
+	}
+
+	// The replacer tries the secrets in order at each position: the longest ones go first,
+	// so a secret is not partially replaced because another one is its prefix
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, secret := range secrets {
+		pairs = append(pairs, secret, replacement)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	return func(s string) string {
+		if s == "" {
+			return s
+		}
+
+		s = replacer.Replace(s)
+		for _, pattern := range patterns {
+			s = redactPattern(s, pattern, replacement)
+		}
+
+		return s
+	}
+}
+
+// redactPattern replaces the matches of pattern in s by replacement, or only their capture groups if pattern has some
+func redactPattern(s string, pattern *regexp.Regexp, replacement string) string {
+	if pattern.NumSubexp() == 0 {
+		return pattern.ReplaceAllLiteralString(s, replacement)
+	}
+
+	var redacted strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringSubmatchIndex(s, -1) {
+		for group := 1; group <= pattern.NumSubexp(); group++ {
+			start, end := match[2*group], match[2*group+1]
+			// Skip the groups not matched or nested in a redacted group
+			if start < 0 || start < last {
+				continue
+			}
+
+			redacted.WriteString(s[last:start])
+			redacted.WriteString(replacement)
+			last = end
+		}
+	}
+	redacted.WriteString(s[last:])
+
+	return redacted.String()
+}
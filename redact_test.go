@@ -0,0 +1,124 @@
+package parallexe
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestRedactPattern(t *testing.T) {
+	patterns := map[string][]string{
+		`token=[a-z0-9]+`:           {"auth token=abc123 ok", "auth *** ok"},
+		`password=(\S+)`:            {"user=me password=s3cr3t password=other", "user=me password=*** password=***"},
+		`(user)=(\S+) key=(\S+)`:    {"user=me key=k3y", "***=*** key=***"},
+		`secret: ("[^"]*"|\S+)`:     {`secret: "a b" other`, `secret: *** other`},
+		`optional(=(\d+))?`:         {"optional and optional=1", "optional and optional***"},
+		`nested=((\w+)-(\w+))`:      {"nested=a-b", "nested=***"},
+		`no match in this (string)`: {"nothing", "nothing"},
+	}
+
+	for pattern, values := range patterns {
+		if redacted := redactPattern(values[0], regexp.MustCompile(pattern), "***"); redacted != values[1] {
+			t.Errorf("Expected %q to be redacted by %s as %q, got %q", values[0], pattern, values[1], redacted)
+		}
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	t.Run("Prefix of a longer secret", func(t *testing.T) {
+		for _, secrets := range [][]string{{"abcd", "abcdefgh"}, {"abcdefgh", "abcd"}} {
+			redact := HostConnection{}.redactor(&RedactConfig{Secrets: secrets})
+			if redacted := redact("key=abcdefgh other=abcd"); redacted != "key=[REDACTED] other=[REDACTED]" {
+				t.Errorf("Expected the longest secret to be redacted entirely with %v, got %q", secrets, redacted)
+			}
+		}
+	})
+}
+
+func TestRedactConfig(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	t.Run("Literals and patterns", func(t *testing.T) {
+		responses, err := pexe.Exec("echo token=abc123 user=admin; echo admin >&2; exit 3", &ExecConfig{Redact: &RedactConfig{
+			Secrets:     []string{"admin"},
+			Patterns:    []*regexp.Regexp{regexp.MustCompile(`token=(\w+)`)},
+			Replacement: "***",
+		}})
+		if err == nil {
+			t.Fatalf("Expected an error because of stderr")
+		}
+
+		response := responses.HostResponses["localhost"]
+		if response.Stdout != "token=*** user=***\n" || response.Stderr != "***\n" || response.Code != 3 {
+			t.Errorf("Expected the output to be redacted, got %+v", response)
+		}
+	})
+
+	t.Run("Sensitive variables", func(t *testing.T) {
+		responses, err := pexe.Exec("echo {{ .db.password }} {{ .port }} {{ .name }}", &ExecConfig{
+			CompileTemplate: true,
+			ExecVariables: &ExecVariables{
+				Variables: KeyValueVariable{
+					"db":   map[string]interface{}{"password": "p4ss"},
+					"port": 5432,
+					"name": "app",
+				},
+				Sensitive: []string{"db", "port"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "[REDACTED] [REDACTED] app\n" {
+			t.Errorf("Expected sensitive variables to be redacted, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("Become password", func(t *testing.T) {
+		responses, err := pexe.Exec("echo p4ssw0rd", &ExecConfig{
+			BecomePassword: "p4ssw0rd",
+			Redact:         &RedactConfig{Secrets: []string{"admin"}},
+		})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "[REDACTED]\n" {
+			t.Errorf("Expected the become password to be redacted, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("Edited files are not redacted", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "parallexe-redact")
+		if err != nil {
+			t.Fatalf("Error during directory test creation: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		filePath := filepath.Join(dir, "app.conf")
+		if err := os.WriteFile(filePath, []byte("password=s3cr3t\n"), 0644); err != nil {
+			t.Fatalf("Error during file creation: %v", err)
+		}
+
+		responses, err := pexe.LineInFile(filePath, "debug=true", &LineInFileConfig{
+			ExecConfig: &ExecConfig{Redact: &RedactConfig{Secrets: []string{"s3cr3t"}}},
+		})
+		if err != nil {
+			t.Fatalf("Error during LineInFile: %v", err)
+		}
+
+		content, _ := os.ReadFile(filePath)
+		if string(content) != "password=s3cr3t\ndebug=true\n" {
+			t.Errorf("Expected the file to keep the secret, got %q", string(content))
+		}
+		if diff := responses.HostResponses["localhost"].Diff; diff == "" || regexp.MustCompile(`s3cr3t`).MatchString(diff) {
+			t.Errorf("Expected the diff to be redacted, got %q", diff)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package parallexe
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// RetryConfig defines how a failed command is retried, or how a command is re-run until a condition is met.
+// A command is retried when it fails as defined by CommandResponse.Success: error, stderr output or non-zero exit code.
+// This differs from the error of Exec and from CommandStatusFailed, which only consider the error and the stderr output:
+// a command still exiting with a non-zero code and no stderr output after its last attempt is reported as done,
+// with Success false and its Code.
+type RetryConfig struct {
+	// Attempts is the maximum number of times the command is run, including the first one. Default is 1.
+	Attempts int
+	// Delay is the time waited before the second attempt
+	Delay time.Duration
+	// Backoff multiplies the delay after each attempt (e.g. 2 for an exponential backoff). Default is 1 (constant delay).
+	Backoff float64
+	// MaxDelay is the maximum delay between two attempts. If zero, the delay is not limited.
+	MaxDelay time.Duration
+	// OnConnectionError retries the command only when it can't be executed (CommandResponse.Error, e.g. SSH connection errors).
+	// OnExitCodes retries the command only when it exits with one of these codes.
+	// If both are empty, the command is retried on any failure (error, stderr output or non-zero exit code).
+	OnConnectionError bool
+	OnExitCodes       []int
+	// Until re-runs the command until it returns true, whether the command succeeded or not.
+	// If the condition is still not met after Attempts, the response gets an error.
+	Until func(response *CommandResponse) bool
+	// UntilRegexp re-runs the command until its stdout matches this regular expression, as Until
+	UntilRegexp string
+}
+
+// retryRules contains the RetryConfig with its regular expression compiled
+type retryRules struct {
+	config      RetryConfig
+	untilRegexp *regexp.Regexp
+}
+
+// compileRetryRules validates and compiles a RetryConfig. A nil config returns rules running the command once.
+func compileRetryRules(config *RetryConfig) (*retryRules, error) {
+	rules := &retryRules{}
+	if config == nil {
+		return rules, nil
+	}
+
+	if config.Attempts < 0 || config.Delay < 0 || config.Backoff < 0 || config.MaxDelay < 0 {
+		return nil, fmt.Errorf("retry attempts, delay, backoff and max delay can't be negative")
+	}
+
+	rules.config = *config
+
+	if config.UntilRegexp != "" {
+		untilRegexp, err := regexp.Compile(config.UntilRegexp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid until regexp %s: %v", config.UntilRegexp, err)
+		}
+		rules.untilRegexp = untilRegexp
+	}
+
+	return rules, nil
+}
+
+// hasUntil returns true if the command is re-run until a condition is met
+func (rules *retryRules) hasUntil() bool {
+	return rules.config.Until != nil || rules.untilRegexp != nil
+}
+
+// untilMet returns true if response meets the Until and UntilRegexp conditions
+func (rules *retryRules) untilMet(response *CommandResponse) bool {
+	if rules.config.Until != nil && !rules.config.Until(response) {
+		return false
+	}
+
+	return rules.untilRegexp == nil || rules.untilRegexp.MatchString(response.Stdout)
+}
+
+// shouldRetry returns true if a failed response must be retried.
+// A response fails with an error, a stderr output or a non-zero exit code (see RetryConfig).
+func (rules *retryRules) shouldRetry(response *CommandResponse) bool {
+	if response.Error == nil && response.Stderr == "" && response.Code == 0 {
+		return false
+	}
+
+	if !rules.config.OnConnectionError && len(rules.config.OnExitCodes) == 0 {
+		return true
+	}
+
+	if rules.config.OnConnectionError && response.Error != nil {
+		return true
+	}
+
+	return response.Error == nil && slices.Contains(rules.config.OnExitCodes, response.Code)
+}
+
+// execute runs execute until it succeeds, or until the Until conditions are met, at most Attempts times.
+// The returned response is the last one, with the number of attempts.
+func (rules *retryRules) execute(execute func() *CommandResponse) *CommandResponse {
+	attempts := rules.config.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := rules.config.Delay
+
+	var response *CommandResponse
+	for attempt := 1; attempt <= attempts; attempt++ {
+		response = execute()
+		response.Attempts = attempt
+
+		if rules.hasUntil() {
+			if rules.untilMet(response) {
+				return response
+			}
+		} else if !rules.shouldRetry(response) {
+			return response
+		}
+
+		if attempt < attempts {
+			time.Sleep(delay)
+			delay = rules.nextDelay(delay)
+		}
+	}
+
+	if rules.hasUntil() && response.Error == nil {
+		response.Error = fmt.Errorf("condition not met after %d attempts", attempts)
+		response.Success = false
+	}
+
+	return response
+}
+
+// nextDelay returns the delay after delay, multiplied by Backoff and limited to MaxDelay
+func (rules *retryRules) nextDelay(delay time.Duration) time.Duration {
+	if rules.config.Backoff > 0 {
+		delay = time.Duration(float64(delay) * rules.config.Backoff)
+	}
+
+	if rules.config.MaxDelay > 0 && delay > rules.config.MaxDelay {
+		delay = rules.config.MaxDelay
+	}
+
+	return delay
+}
@@ -0,0 +1,141 @@
+package parallexe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExecRetry(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	dir, err := os.MkdirTemp("", "parallexe-retry")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// flakyCommand counts its runs in a file and exits with code until it has been run runs times
+	flakyCommand := func(name string, runs int, code int) string {
+		counter := ShellQuote(filepath.Join(dir, name))
+		return fmt.Sprintf("echo x >> %s; n=$(wc -l < %s); echo $n; [ $n -ge %d ] || exit %d", counter, counter, runs, code)
+	}
+
+	t.Run("Retry until success", func(t *testing.T) {
+		responses, err := pexe.Exec(flakyCommand("success", 3, 1), &ExecConfig{
+			Retry: &RetryConfig{Attempts: 5, Delay: time.Millisecond, Backoff: 2},
+		})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		response := responses.HostResponses["localhost"]
+		if response.Attempts != 3 || response.Code != 0 {
+			t.Errorf("Expected success after 3 attempts, got %d attempts and code %d", response.Attempts, response.Code)
+		}
+	})
+
+	t.Run("Retry only on exit codes", func(t *testing.T) {
+		responses, err := pexe.Exec(flakyCommand("codes", 3, 2), &ExecConfig{
+			Retry: &RetryConfig{Attempts: 5, OnExitCodes: []int{1}},
+		})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		response := responses.HostResponses["localhost"]
+		if response.Attempts != 1 || response.Code != 2 {
+			t.Errorf("Expected no retry, got %d attempts and code %d", response.Attempts, response.Code)
+		}
+	})
+
+	t.Run("Attempts exhausted without stderr", func(t *testing.T) {
+		responses, err := pexe.Exec(flakyCommand("exhausted", 10, 1), &ExecConfig{
+			Retry: &RetryConfig{Attempts: 2},
+		})
+		// The command is retried on its exit code, but Exec only reports errors and stderr outputs
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		response := responses.HostResponses["localhost"]
+		if response.Attempts != 2 || response.Code != 1 || response.Success {
+			t.Errorf("Expected a failed response after 2 attempts, got %+v", response)
+		}
+	})
+
+	t.Run("Until regexp", func(t *testing.T) {
+		responses, err := pexe.Exec(flakyCommand("until", 1, 0), &ExecConfig{
+			Retry: &RetryConfig{Attempts: 5, UntilRegexp: `(?m)^4$`},
+		})
+		if err != nil {
+			t.Fatalf("Error during Exec: %v", err)
+		}
+
+		response := responses.HostResponses["localhost"]
+		if response.Attempts != 4 || response.Stdout != "4\n" {
+			t.Errorf("Expected 4 attempts, got %d attempts and output %q", response.Attempts, response.Stdout)
+		}
+	})
+
+	t.Run("Until not met", func(t *testing.T) {
+		responses, err := pexe.Exec("echo starting", &ExecConfig{
+			Retry: &RetryConfig{Attempts: 2, Until: func(response *CommandResponse) bool {
+				return response.Stdout == "ready\n"
+			}},
+		})
+		if err == nil {
+			t.Fatalf("Expected an error when the condition is not met")
+		}
+
+		response := responses.HostResponses["localhost"]
+		if response.Attempts != 2 || response.Error == nil {
+			t.Errorf("Expected an error after 2 attempts, got %d attempts and error %v", response.Attempts, response.Error)
+		}
+	})
+
+	t.Run("Step retry overrides ExecConfig retry", func(t *testing.T) {
+		responses, err := pexe.MultiExecSteps([]Step{
+			{Command: "echo first"},
+			{Command: flakyCommand("step", 2, 1), Retry: &RetryConfig{Attempts: 2}},
+		}, &ExecConfig{Retry: &RetryConfig{Attempts: 1}})
+		if err != nil {
+			t.Fatalf("Error during MultiExecSteps: %v", err)
+		}
+
+		if responses[0].HostResponses["localhost"].Attempts != 1 {
+			t.Errorf("Expected 1 attempt for first step, got %d", responses[0].HostResponses["localhost"].Attempts)
+		}
+		if responses[1].HostResponses["localhost"].Attempts != 2 {
+			t.Errorf("Expected 2 attempts for second step, got %d", responses[1].HostResponses["localhost"].Attempts)
+		}
+	})
+
+	t.Run("Invalid until regexp", func(t *testing.T) {
+		_, err := pexe.Exec("echo", &ExecConfig{Retry: &RetryConfig{UntilRegexp: "("}})
+		if err == nil {
+			t.Fatalf("Expected an error for an invalid regexp")
+		}
+	})
+}
+
+func TestRetryNextDelay(t *testing.T) {
+	rules, err := compileRetryRules(&RetryConfig{Delay: time.Second, Backoff: 2, MaxDelay: 3 * time.Second})
+	if err != nil {
+		t.Fatalf("Error during retry rules compilation: %v", err)
+	}
+
+	delay := time.Second
+	for _, expected := range []time.Duration{2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		delay = rules.nextDelay(delay)
+		if delay != expected {
+			t.Errorf("Expected delay %v, got %v", expected, delay)
+		}
+	}
+}
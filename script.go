@@ -0,0 +1,127 @@
+package parallexe
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type ScriptConfig struct {
+	// ExecConfig allows to filter hosts and groups, and defines the environment, the working directory,
+	// the stdin and the user the script is run with
+	ExecConfig *ExecConfig
+	// CompileTemplate If the script is a go template, Parallexe will compile it for each host before uploading it
+	CompileTemplate bool
+	// TemplateFS is the file system from which the script and Partials are read (e.g. an embed.FS).
+	// If nil, they are read from the local disk.
+	TemplateFS fs.FS
+	// Partials contains glob patterns of shared templates parsed with the script template, as in SendConfig
+	Partials []string
+	// ExecVariables contains the variables for compiling the script template
+	ExecVariables *ExecVariables
+	// Interpreter runs the script (e.g. bash, python3 -u). It is split on spaces.
+	// If empty, the script is executed directly and its shebang is used.
+	Interpreter string
+	// Args contains the arguments given to the script. Each argument is quoted, so it is received as is.
+	Args []string
+	// TempDir is the directory where the script is uploaded on the hosts. Default is /tmp.
+	TempDir string
+}
+
+// Script uploads a local script to a temporary file on remote hosts, executes it and removes it afterward,
+// even if it fails. The script output and exit code are returned in a CommandResponse per host.
+// If config.CompileTemplate is true, the script is rendered for each host as in Send.
+func (p *Parallexe) Script(scriptPath string, config *ScriptConfig) (*CommandResponses, error) {
+	if config == nil {
+		config = &ScriptConfig{}
+	}
+
+	for _, arg := range append(strings.Fields(config.Interpreter), config.Args...) {
+		if strings.ContainsRune(arg, 0) {
+			return nil, fmt.Errorf("argument %q contains a NUL byte", arg)
+		}
+	}
+
+	content, err := readTemplateFile(config.TemplateFS, scriptPath)
+	if err != nil {
+		return nil, err
+	}
+
+	txtContent := string(content)
+	scriptContent := func(hostConnection HostConnection) (string, error) {
+		return txtContent, nil
+	}
+
+	if config.CompileTemplate {
+		// Parse the template and its partials
+		tmpl, err := parseTemplate(config.TemplateFS, scriptPath, config.Partials)
+		if err != nil {
+			return nil, err
+		}
+
+		// Render the template with the provided data per host
+		scriptContent = func(hostConnection HostConnection) (string, error) {
+			variables, err := templateVariables(hostConnection, config.ExecVariables)
+			if err != nil {
+				return "", err
+			}
+			return renderTemplate(tmpl, hostConnection.HostConfig.Host, variables)
+		}
+	}
+
+	// White list HostSession to execute only on desired hosts
+	filteredHosts := getFilteredHosts(p.HostConnections, config.ExecConfig)
+
+	execConfig, err := prepareCommandInput(scriptExecConfig(config.ExecConfig), filteredHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return executeOnHosts(filteredHosts, execConfig, func(hostConnection HostConnection) *CommandResponse {
+		hostScript, err := scriptContent(hostConnection)
+		if err != nil {
+			return newErrorResponse(err)
+		}
+
+		return hideFileContent(executeCommandOnHost(hostConnection, scriptCommand(scriptPath, hostScript, config), execConfig), hostScript)
+	})
+}
+
+// scriptExecConfig returns a copy of execConfig running commands with sh, as the scripts of the built-in operations are POSIX shell scripts
+func scriptExecConfig(execConfig *ExecConfig) *ExecConfig {
+	scriptConfig := ExecConfig{}
+	if execConfig != nil {
+		scriptConfig = *execConfig
+	}
+	scriptConfig.Shell = "sh"
+	scriptConfig.CompileTemplate = false
+
+	return &scriptConfig
+}
+
+// scriptCommand returns a shell script uploading content to a temporary file readable by its owner only,
+// running it with config.Interpreter and config.Args, and removing it on exit.
+// The exit code of the script is kept.
+func scriptCommand(scriptPath string, content string, config *ScriptConfig) string {
+	tempDir := config.TempDir
+	if tempDir == "" {
+		tempDir = "/tmp"
+	}
+
+	remotePath := path.Join(tempDir, fmt.Sprintf("parallexe-%s-%s", randomSuffix(), filepath.Base(scriptPath)))
+
+	run := append(strings.Fields(config.Interpreter), remotePath)
+	run = append(run, config.Args...)
+
+	return strings.Join([]string{
+		fmt.Sprintf("script=%s", ShellQuote(remotePath)),
+		`trap 'rm -f -- "$script"' EXIT`,
+		// The file is written in a subshell, as writeFileCommand sets its own trap
+		"(",
+		writeFileCommand(remotePath, content, fileAttributes{Mode: 0700}, false),
+		") || exit 1",
+		ShellJoin(run...),
+	}, "\n")
+}
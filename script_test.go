@@ -0,0 +1,118 @@
+package parallexe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestScript(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost", Groups: []string{"web"}}, {Host: "127.0.0.1", Groups: []string{"db"}}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	tempDir, err := os.MkdirTemp("", "parallexe-script")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fsys := fstest.MapFS{
+		"deploy.sh":     {Data: []byte("#!/bin/sh\necho \"$# $1\"\necho {{ .role }}\n")},
+		"fail.sh":       {Data: []byte("echo before\nexit 3\n")},
+		"read_stdin.sh": {Data: []byte("cat\n")},
+	}
+
+	t.Run("Script run with interpreter and arguments", func(t *testing.T) {
+		responses, err := pexe.Script("deploy.sh", &ScriptConfig{
+			TemplateFS:  fsys,
+			Interpreter: "sh",
+			Args:        []string{"it's $(id)", "b"},
+			TempDir:     tempDir,
+		})
+		if err != nil {
+			t.Fatalf("Error during Script: %v", err)
+		}
+
+		for host, response := range responses.HostResponses {
+			if response.Stdout != "2 it's $(id)\n{{ .role }}\n" {
+				t.Errorf("Wrong output on host %s, got %q", host, response.Stdout)
+			}
+		}
+	})
+
+	t.Run("Script rendered per host and run with its shebang", func(t *testing.T) {
+		responses, err := pexe.Script("deploy.sh", &ScriptConfig{
+			TemplateFS:      fsys,
+			CompileTemplate: true,
+			ExecVariables: &ExecVariables{GroupVariables: map[string]KeyValueVariable{
+				"web": {"role": "frontend"},
+				"db":  {"role": "database"},
+			}},
+			TempDir: tempDir,
+		})
+		if err != nil {
+			t.Fatalf("Error during Script: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "0 \nfrontend\n" {
+			t.Errorf("Wrong output on localhost, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+		if responses.HostResponses["127.0.0.1"].Stdout != "0 \ndatabase\n" {
+			t.Errorf("Wrong output on 127.0.0.1, got %q", responses.HostResponses["127.0.0.1"].Stdout)
+		}
+	})
+
+	t.Run("Script removed on failure", func(t *testing.T) {
+		responses, err := pexe.Script("fail.sh", &ScriptConfig{
+			ExecConfig:  &ExecConfig{Hosts: []string{"localhost"}},
+			TemplateFS:  fsys,
+			Interpreter: "sh",
+			TempDir:     tempDir,
+		})
+		if err != nil {
+			t.Fatalf("Error during Script: %v", err)
+		}
+
+		response := responses.HostResponses["localhost"]
+		if response.Code != 3 || response.Stdout != "before\n" {
+			t.Errorf("Expected code 3 and output, got %d %q", response.Code, response.Stdout)
+		}
+	})
+
+	t.Run("Script stdin", func(t *testing.T) {
+		responses, err := pexe.Script("read_stdin.sh", &ScriptConfig{
+			ExecConfig:  &ExecConfig{Hosts: []string{"localhost"}, Stdin: strings.NewReader("input")},
+			TemplateFS:  fsys,
+			Interpreter: "sh",
+			TempDir:     tempDir,
+		})
+		if err != nil {
+			t.Fatalf("Error during Script: %v", err)
+		}
+
+		if responses.HostResponses["localhost"].Stdout != "input" {
+			t.Errorf("Expected stdin, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("Missing script", func(t *testing.T) {
+		_, err := pexe.Script("missing.sh", &ScriptConfig{TemplateFS: fsys})
+		if err == nil {
+			t.Fatalf("Expected an error for a missing script")
+		}
+	})
+
+	// All the uploaded scripts have been removed
+	files, err := filepath.Glob(filepath.Join(tempDir, "*"))
+	if err != nil {
+		t.Fatalf("Error during temporary directory listing: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected scripts to be removed, got %v", files)
+	}
+}
@@ -0,0 +1,255 @@
+package parallexe
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// minSecretLength is the minimum length of the secret values redacted from the responses:
+// shorter values (e.g. a port or a flag) would redact unrelated parts of the output
+const minSecretLength = 4
+
+// SecretStore decrypts the secret files referenced by SecretRef variables.
+// Files are encrypted with age (https://age-encryption.org), with a passphrase or an X25519 key, in binary or armored format.
+// Decrypted files are cached in memory.
+type SecretStore struct {
+	// Dir is the directory of the relative paths of the secret files. Default is the working directory.
+	Dir string
+
+	identities []age.Identity
+
+	m     sync.Mutex
+	files map[string][]byte
+}
+
+// SecretRef is a variable value decrypted from a secret file of ExecVariables.Secrets when a template is rendered.
+// Its value is redacted from the responses of the operation it has been rendered for, on this host only.
+// Values (or lines of multiline values) shorter than 4 characters are not redacted.
+type SecretRef struct {
+	// File is the path of the encrypted file
+	File string
+	// Key selects a value of the file, decrypted as a YAML map. If empty, the value is the whole file without its trailing newline.
+	Key string
+}
+
+// NewPassphraseSecretStore returns a SecretStore decrypting files encrypted with passphrase (age -p)
+func NewPassphraseSecretStore(passphrase string) (*SecretStore, error) {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretStore{identities: []age.Identity{identity}}, nil
+}
+
+// NewKeySecretStore returns a SecretStore decrypting files encrypted for the X25519 keys read from keys,
+// in the format of age-keygen (AGE-SECRET-KEY-1... lines, # comments)
+func NewKeySecretStore(keys io.Reader) (*SecretStore, error) {
+	identities, err := age.ParseIdentities(keys)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse secret keys: %v", err)
+	}
+
+	return &SecretStore{identities: identities}, nil
+}
+
+// Decrypt returns the value of a secret
+func (s *SecretStore) Decrypt(ref SecretRef) (string, error) {
+	content, err := s.decryptFile(ref.File)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.Key == "" {
+		return strings.TrimSuffix(string(content), "\n"), nil
+	}
+
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return "", fmt.Errorf("can't parse secret file %s: %v", ref.File, err)
+	}
+
+	value, ok := values[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s not found in %s", ref.Key, ref.File)
+	}
+
+	return fmt.Sprint(value), nil
+}
+
+// decryptFile returns the decrypted content of a secret file
+func (s *SecretStore) decryptFile(file string) ([]byte, error) {
+	if s.Dir != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(s.Dir, file)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if content, ok := s.files[file]; ok {
+		return content, nil
+	}
+
+	encrypted, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader = bytes.NewReader(encrypted)
+	if bytes.HasPrefix(bytes.TrimSpace(encrypted), []byte(armor.Header)) {
+		reader = armor.NewReader(reader)
+	}
+
+	decrypter, err := age.Decrypt(reader, s.identities...)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt secret file %s: %v", file, err)
+	}
+
+	content, err := io.ReadAll(decrypter)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt secret file %s: %v", file, err)
+	}
+
+	if s.files == nil {
+		s.files = make(map[string][]byte)
+	}
+	s.files[file] = content
+
+	return content, nil
+}
+
+// resolveSecrets returns value with its SecretRef (including the ones nested in maps and lists) replaced by their value.
+// The values are added to the secrets of hostConnection, to be redacted from the responses of the running operation.
+func resolveSecrets(value interface{}, store *SecretStore, hostConnection HostConnection) (interface{}, error) {
+	switch typed := value.(type) {
+	case SecretRef:
+		return resolveSecret(typed, store, hostConnection)
+	case *SecretRef:
+		return resolveSecret(*typed, store, hostConnection)
+	case []interface{}:
+		resolved := make([]interface{}, len(typed))
+		for index, nested := range typed {
+			nestedValue, err := resolveSecrets(nested, store, hostConnection)
+			if err != nil {
+				return nil, err
+			}
+			resolved[index] = nestedValue
+		}
+		return resolved, nil
+	}
+
+	if valueMap, ok := variableMap(value); ok {
+		resolved := make(map[string]interface{}, len(valueMap))
+		for key, nested := range valueMap {
+			nestedValue, err := resolveSecrets(nested, store, hostConnection)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = nestedValue
+		}
+		return resolved, nil
+	}
+
+	return value, nil
+}
+
+// resolveSecret decrypts a secret and adds its value to the secrets of hostConnection
+func resolveSecret(ref SecretRef, store *SecretStore, hostConnection HostConnection) (string, error) {
+	if store == nil {
+		return "", fmt.Errorf("can't decrypt secret file %s: ExecVariables.Secrets is not set", ref.File)
+	}
+
+	value, err := store.Decrypt(ref)
+	if err != nil {
+		return "", err
+	}
+
+	hostConnection.addSecret(value)
+
+	return value, nil
+}
+
+// addSensitiveValue adds the strings and numbers of a sensitive variable value (including the ones nested in maps and lists)
+// to the secrets of the host
+func (hostConnection HostConnection) addSensitiveValue(value interface{}) {
+	switch typed := value.(type) {
+	case string:
+		hostConnection.addSecret(typed)
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		hostConnection.addSecret(fmt.Sprint(typed))
+	case []interface{}:
+		for _, nested := range typed {
+			hostConnection.addSensitiveValue(nested)
+		}
+	default:
+		if valueMap, ok := variableMap(value); ok {
+			for _, nested := range valueMap {
+				hostConnection.addSensitiveValue(nested)
+			}
+		}
+	}
+}
+
+// secretScope contains the secret values rendered for a host during an operation
+type secretScope struct {
+	m       sync.Mutex
+	secrets []string
+}
+
+// withSecretScope returns a copy of hostConnection collecting the secrets rendered for a new operation
+func (hostConnection HostConnection) withSecretScope() HostConnection {
+	hostConnection.secrets = &secretScope{}
+	return hostConnection
+}
+
+// withSecretScopes returns copies of hostConnections collecting the secrets rendered for a new operation
+func withSecretScopes(hostConnections []HostConnection) []HostConnection {
+	scopedHosts := make([]HostConnection, len(hostConnections))
+	for index, hostConnection := range hostConnections {
+		scopedHosts[index] = hostConnection.withSecretScope()
+	}
+
+	return scopedHosts
+}
+
+// addSecret adds a value to redact from the responses of the running operation on the host.
+// Each line of a multiline value is redacted, unless it is shorter than minSecretLength.
+func (hostConnection HostConnection) addSecret(value string) {
+	if hostConnection.secrets == nil {
+		return
+	}
+
+	hostConnection.secrets.m.Lock()
+	defer hostConnection.secrets.m.Unlock()
+
+	scanner := bufio.NewScanner(strings.NewReader(value))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) >= minSecretLength && !slices.Contains(hostConnection.secrets.secrets, line) {
+			hostConnection.secrets.secrets = append(hostConnection.secrets.secrets, line)
+		}
+	}
+}
+
+// renderedSecrets returns the secret values rendered for the host by the running operation
+func (hostConnection HostConnection) renderedSecrets() []string {
+	if hostConnection.secrets == nil {
+		return nil
+	}
+
+	hostConnection.secrets.m.Lock()
+	defer hostConnection.secrets.m.Unlock()
+
+	return slices.Clone(hostConnection.secrets.secrets)
+}
@@ -0,0 +1,206 @@
+package parallexe
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// writeSecretFile encrypts content for recipient in an armored file
+func writeSecretFile(t *testing.T, path string, content string, recipient age.Recipient) {
+	var encrypted bytes.Buffer
+	armorWriter := armor.NewWriter(&encrypted)
+	writer, err := age.Encrypt(armorWriter, recipient)
+	if err != nil {
+		t.Fatalf("Error during secret encryption: %v", err)
+	}
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatalf("Error during secret encryption: %v", err)
+	}
+	writer.Close()
+	armorWriter.Close()
+
+	if err := os.WriteFile(path, encrypted.Bytes(), 0600); err != nil {
+		t.Fatalf("Error during secret file creation: %v", err)
+	}
+}
+
+func TestSecretStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "parallexe-secrets")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("Passphrase", func(t *testing.T) {
+		recipient, err := age.NewScryptRecipient("passphrase")
+		if err != nil {
+			t.Fatalf("Error during recipient creation: %v", err)
+		}
+		recipient.SetWorkFactor(10)
+		writeSecretFile(t, filepath.Join(dir, "db.age"), "password: s3cr3t\nport: 5432\n", recipient)
+
+		store, err := NewPassphraseSecretStore("passphrase")
+		if err != nil {
+			t.Fatalf("Error during store creation: %v", err)
+		}
+		store.Dir = dir
+
+		if value, err := store.Decrypt(SecretRef{File: "db.age", Key: "port"}); err != nil || value != "5432" {
+			t.Errorf("Expected port 5432, got %q (%v)", value, err)
+		}
+		if _, err := store.Decrypt(SecretRef{File: "db.age", Key: "user"}); err == nil {
+			t.Errorf("Expected an error for an unknown key")
+		}
+
+		wrongStore, _ := NewPassphraseSecretStore("wrong")
+		if _, err := wrongStore.Decrypt(SecretRef{File: filepath.Join(dir, "db.age")}); err == nil {
+			t.Errorf("Expected an error with a wrong passphrase")
+		}
+	})
+
+	t.Run("X25519 key", func(t *testing.T) {
+		identity, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatalf("Error during key generation: %v", err)
+		}
+		writeSecretFile(t, filepath.Join(dir, "token.age"), "t0k3n\n", identity.Recipient())
+
+		store, err := NewKeySecretStore(strings.NewReader("# key\n" + identity.String() + "\n"))
+		if err != nil {
+			t.Fatalf("Error during store creation: %v", err)
+		}
+
+		if value, err := store.Decrypt(SecretRef{File: filepath.Join(dir, "token.age")}); err != nil || value != "t0k3n" {
+			t.Errorf("Expected token t0k3n, got %q (%v)", value, err)
+		}
+	})
+}
+
+func TestSecretVariables(t *testing.T) {
+	dir, err := os.MkdirTemp("", "parallexe-secrets")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("Error during key generation: %v", err)
+	}
+	writeSecretFile(t, filepath.Join(dir, "password.age"), "s3cr3t\n", identity.Recipient())
+
+	store, err := NewKeySecretStore(strings.NewReader(identity.String()))
+	if err != nil {
+		t.Fatalf("Error during store creation: %v", err)
+	}
+	store.Dir = dir
+
+	pexe, err := New([]HostConfig{{Host: "localhost"}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	execVariables := &ExecVariables{
+		Secrets:       store,
+		HostVariables: map[string]KeyValueVariable{"localhost": {"db": map[string]interface{}{"password": SecretRef{File: "password.age"}}}},
+	}
+
+	responses, err := pexe.Exec("{{ if .db }}echo password={{ .db.password }}; {{ end }}echo s3cr3t >&2", &ExecConfig{CompileTemplate: true, ExecVariables: execVariables})
+	if err == nil {
+		t.Fatalf("Expected an error because of stderr")
+	}
+
+	if responses.HostResponses["localhost"].Stdout != "password=[REDACTED]\n" || responses.HostResponses["localhost"].Stderr != "[REDACTED]\n" {
+		t.Errorf("Expected the secret to be redacted, got %+v", responses.HostResponses["localhost"])
+	}
+	// The secret has not been rendered for 127.0.0.1
+	if responses.HostResponses["127.0.0.1"].Stderr != "s3cr3t\n" {
+		t.Errorf("Expected the output of 127.0.0.1 not to be redacted, got %+v", responses.HostResponses["127.0.0.1"])
+	}
+
+	t.Run("Redacted error", func(t *testing.T) {
+		hostConnection := pexe.HostConnections[0].withSecretScope()
+		hostConnection.addSecret("s3cr3t")
+		cause := errors.New("connection refused by s3cr3t")
+
+		commandResponse := hostConnection.redactResponse(newErrorResponse(cause), nil)
+		if commandResponse.Error.Error() != "connection refused by [REDACTED]" {
+			t.Errorf("Expected the error to be redacted, got %v", commandResponse.Error)
+		}
+		if errors.Unwrap(commandResponse.Error) != nil || errors.Is(commandResponse.Error, cause) {
+			t.Errorf("Expected the original error not to be reachable from the redacted error")
+		}
+	})
+
+	t.Run("Secrets scoped to the operation", func(t *testing.T) {
+		responses, _ := pexe.Exec("echo s3cr3t", &ExecConfig{Hosts: []string{"localhost"}})
+
+		if responses.HostResponses["localhost"].Stdout != "s3cr3t\n" {
+			t.Errorf("Expected the secret of a previous operation not to be redacted, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("Short values are not redacted", func(t *testing.T) {
+		responses, _ := pexe.Exec("echo {{ .debug }} {{ .token }}", &ExecConfig{
+			Hosts:           []string{"localhost"},
+			CompileTemplate: true,
+			ExecVariables: &ExecVariables{
+				Variables: KeyValueVariable{"debug": "on", "token": "t0k3n"},
+				Sensitive: []string{"debug", "token"},
+			},
+		})
+
+		if responses.HostResponses["localhost"].Stdout != "on [REDACTED]\n" {
+			t.Errorf("Expected only the long value to be redacted, got %q", responses.HostResponses["localhost"].Stdout)
+		}
+	})
+
+	t.Run("No secret store", func(t *testing.T) {
+		responses, _ := pexe.Exec("echo {{ .password }}", &ExecConfig{
+			Hosts:           []string{"localhost"},
+			CompileTemplate: true,
+			ExecVariables:   &ExecVariables{Variables: KeyValueVariable{"password": SecretRef{File: "password.age"}}},
+		})
+
+		if responses.HostResponses["localhost"].Error == nil {
+			t.Errorf("Expected an error without secret store")
+		}
+	})
+
+	t.Run("Secrets in playbook tasks", func(t *testing.T) {
+		report, err := pexe.RunPlaybook(&Playbook{
+			Hosts: []string{"localhost"},
+			Tasks: []PlaybookTask{{Exec: "echo {{ .password }} {{ .token }}"}},
+		}, &ExecConfig{ExecVariables: &ExecVariables{
+			Secrets:        store,
+			Sensitive:      []string{"token"},
+			ExtraVariables: KeyValueVariable{"password": SecretRef{File: "password.age"}, "token": "t0k3n"},
+		}})
+		if err != nil {
+			t.Fatalf("Error during RunPlaybook: %v", err)
+		}
+
+		if stdout := report.Tasks[0].HostResponses["localhost"].Stdout; stdout != "[REDACTED] [REDACTED]\n" {
+			t.Errorf("Expected the secrets to be rendered and redacted, got %q", stdout)
+		}
+	})
+
+	t.Run("Explained values are not decrypted", func(t *testing.T) {
+		explanations, err := pexe.ExplainVariables("localhost", execVariables)
+		if err != nil {
+			t.Fatalf("Error during ExplainVariables: %v", err)
+		}
+
+		if _, ok := explanations["db"].Value.(map[string]interface{})["password"].(SecretRef); !ok {
+			t.Errorf("Expected a SecretRef, got %v", explanations["db"].Value)
+		}
+	})
+}
@@ -1,141 +1,203 @@
 package parallexe
 
 import (
-	"bytes"
 	"fmt"
-	"html/template"
-	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
-	"sync"
+	"strconv"
+	"strings"
 )
 
 type SendConfig struct {
 	// ExecConfig allows to filter hosts and groups
 	ExecConfig *ExecConfig
-	// CompileTemplate If sourcePath is a go template, Parallexe will compile this template for each host before sending it
+	// CompileTemplate If sourcePath is a go template, Parallexe will compile this template for each host before sending it.
+	// Templates are rendered with text/template: values are written as is, without the HTML escaping of html/template
+	// used by previous versions (e.g. <, > and & are no longer escaped as &lt;, &gt; and &amp;).
 	CompileTemplate bool
+	// TemplateFS is the file system from which sourcePath and Partials are read (e.g. an embed.FS).
+	// If nil, they are read from the local disk.
+	TemplateFS fs.FS
+	// Partials contains glob patterns of shared templates (partials, layouts) parsed with the source template.
+	// They can be called with {{ template "name" . }} or {{ include "name" . }}, name being the partial file base name
+	// or a {{ define "name" }} block.
+	Partials []string
 	// ExecVariables contains the runtime variables for compiling the templates when sending them.
 	// These variables allow you to customize the rendering of templates for each host.
 	// Variables can be overridden by host group specific variables and host specific variables.
 	ExecVariables *ExecVariables
-	// Owner is the owner of the destination file
+	// Owner is the owner (user name or uid) of the destination file
 	Owner string
-	//	Group is the group of the destination file
-	Mode string
+	// Group is the group (group name or gid) of the destination file
+	Group string
+	// Mode is the permissions of the destination file (e.g. 0644).
+	// os.ModeSetuid, os.ModeSetgid and os.ModeSticky are supported.
+	// If zero, the permissions of the existing file are kept, or the default ones are used for a new file.
+	Mode os.FileMode
+	// SELinuxContext is the SELinux security context of the destination file (e.g. system_u:object_r:etc_t:s0)
+	SELinuxContext string
+	// CreateParents creates the missing parent directories of the destination file (mkdir -p)
+	CreateParents bool
+	// ParentMode is the permissions of the parent directory created with CreateParents. If zero, the default ones are used.
+	ParentMode os.FileMode
 	// IgnoreIfExists indicates whether the upload should be ignored if the destination file already exists on the remote host.
 	// If this value is set to true, the upload will not be performed and no error will be returned if the file already exists.
 	// If this value is set to false, the upload will be performed even if the file already exists, causing it to be overwritten.
 	// The default value is false.
 	IgnoreIfExists bool
+	// Check enables the check mode (dry run): nothing is written on the hosts.
+	// For each host, the rendered content is compared to the current destination file,
+	// and CommandResponse.Diff contains the unified diff that would be applied, with the Owner, Group and Mode changes.
+	// CommandResponses.GroupDiffs groups the hosts having identical diffs.
+	Check bool
 }
 
 // Send sends a source file to a destination on remote hosts.
 // The source file can be a template that will be rendered before sending.
+// If config.TemplateFS is set, the source file is read from it instead of the local disk.
+// Templates are rendered in parallel for the hosts filtered by config.ExecConfig only.
+// A host whose template can't be rendered gets the rendering error in its CommandResponse and nothing is sent to it,
+// while the file is still sent to the other hosts.
+// The file is written atomically: Owner, Group, Mode and SELinuxContext are applied to a temporary file
+// which is then moved to destPath.
 func (p *Parallexe) Send(sourcePath string, destPath string, config *SendConfig) (*CommandResponses, error) {
-	file, err := os.Open(sourcePath)
-	if err != nil {
+	attributes := config.fileAttributes()
+	if err := attributes.validate(); err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	content, err := io.ReadAll(file)
+	content, err := readTemplateFile(config.TemplateFS, sourcePath)
 	if err != nil {
 		return nil, err
 	}
 
 	txtContent := string(content)
-	hostTxtContent := make(map[string]string)
+	hostContent := func(hostConnection HostConnection) (string, error) {
+		return txtContent, nil
+	}
 
 	if config.CompileTemplate {
-		// Parse the template
-		tmpl, err := template.New(filepath.Base(sourcePath)).Parse(txtContent)
+		// Parse the template and its partials
+		tmpl, err := parseTemplate(config.TemplateFS, sourcePath, config.Partials)
 		if err != nil {
 			return nil, err
 		}
 
 		// Render the template with the provided data per host
-		for _, hostConnection := range p.HostConnections {
-			variables := buildVariables(hostConnection.HostConfig, config.ExecVariables)
-
-			// Build variables for this host
-			var rendered bytes.Buffer
-			err = tmpl.Execute(&rendered, variables)
+		hostContent = func(hostConnection HostConnection) (string, error) {
+			variables, err := templateVariables(hostConnection, config.ExecVariables)
 			if err != nil {
-				return nil, err
+				return "", err
 			}
-
-			hostTxtContent[hostConnection.HostConfig.Host] = rendered.String()
+			return renderTemplate(tmpl, hostConnection.HostConfig.Host, variables)
 		}
 	}
 
-	response, err := p.execSend(destPath, hostTxtContent, txtContent, config.IgnoreIfExists, config.ExecConfig)
-	if err != nil {
-		return response, err
+	// White list HostSession to send only to desired hosts
+	filteredHosts := getFilteredHosts(p.HostConnections, config.ExecConfig)
+
+	if config.Check {
+		return checkSend(destPath, filteredHosts, hostContent, config)
 	}
 
-	if config.Owner != "" {
-		response, err = p.Exec(fmt.Sprintf("chown %s %s", config.Owner, destPath), config.ExecConfig)
-		if err != nil {
-			return response, err
-		}
+	return p.execSend(destPath, filteredHosts, hostContent, attributes, config.IgnoreIfExists, config.ExecConfig)
+}
+
+// fileAttributes returns the attributes to apply to the destination file
+func (config *SendConfig) fileAttributes() fileAttributes {
+	return fileAttributes{
+		Owner:          config.Owner,
+		Group:          config.Group,
+		Mode:           config.Mode,
+		SELinuxContext: config.SELinuxContext,
+		CreateParents:  config.CreateParents,
+		ParentMode:     config.ParentMode,
 	}
+}
 
-	if config.Mode != "" {
-		_, err = p.Exec(fmt.Sprintf("chmod %s %s", config.Mode, destPath), config.ExecConfig)
+// execSend executes the actual send command to the destination path on the given hosts.
+// The content to send is built per host by hostContent. If it returns an error, nothing is sent to this host.
+func (p *Parallexe) execSend(destPath string, hostConnections []HostConnection, hostContent func(hostConnection HostConnection) (string, error), attributes fileAttributes, ignoreIfExists bool, execConfig *ExecConfig) (*CommandResponses, error) {
+	execConfig = scriptExecConfig(execConfig)
+
+	return executeOnHosts(hostConnections, execConfig, func(hostConnection HostConnection) *CommandResponse {
+		content, err := hostContent(hostConnection)
 		if err != nil {
-			return response, err
+			return newErrorResponse(err)
 		}
-	}
 
-	return response, nil
+		return hideFileContent(executeCommandOnHost(hostConnection, writeFileCommand(destPath, content, attributes, ignoreIfExists), execConfig), content)
+	})
 }
 
-// execSend executes the actual send command to the destination path on the remote hosts.
-func (p *Parallexe) execSend(destPath string, hostContent map[string]string, content string, ignoreIfExists bool, config *ExecConfig) (*CommandResponses, error) {
-	// Check if file already exist and add a condition if we must override it or not
-	preCommand := ""
-	if ignoreIfExists {
-		preCommand = fmt.Sprintf("[ -f '%s' ] || ", destPath)
-	}
+// checkSend compares the content that would be sent to the current destination file on each host, without writing anything.
+// The returned responses contain the diff per host and whether the file would be changed.
+func checkSend(destPath string, hostConnections []HostConnection, hostContent func(hostConnection HostConnection) (string, error), config *SendConfig) (*CommandResponses, error) {
+	execConfig := scriptExecConfig(config.ExecConfig)
 
-	// Build the final command if the content is the same for all hosts
-	if len(hostContent) == 0 {
-		command := fmt.Sprintf("%sprintf '%s' > %s", preCommand, content, destPath)
-		return p.Exec(command, config)
-	}
+	return executeOnHosts(hostConnections, execConfig, func(hostConnection HostConnection) *CommandResponse {
+		content, err := hostContent(hostConnection)
+		if err != nil {
+			return newErrorResponse(err)
+		}
 
-	// Build specific content for each host
+		file, failedResponse := readRemoteFile(hostConnection, destPath, execConfig)
+		if failedResponse != nil {
+			return failedResponse
+		}
 
-	// White list HostSession to execute only on desired hosts
-	filteredHosts := getFilteredHosts(p.HostConnections, config)
+		var diff string
+		if !file.Exists {
+			diff = "new file\n" + attributesDiff(nil, config) + unifiedDiff("/dev/null", destPath, "", content)
+		} else if !config.IgnoreIfExists {
+			diff = attributesDiff(file.Attributes, config) + unifiedDiff(destPath, destPath, file.Content, content)
+		}
 
-	var wg sync.WaitGroup
-	wg.Add(len(filteredHosts))
+		return &CommandResponse{
+			Stdout:  "",
+			Stderr:  "",
+			Error:   nil,
+			Code:    0,
+			Success: true,
+			Changed: diff != "",
+			Diff:    diff,
+		}
+	})
+}
 
-	commandResponses := make(map[string]*CommandResponse, 0)
-	errorHosts := make([]string, 0)
+// attributesDiff returns the owner, group and mode changes that config would apply to a file.
+// currentAttributes contains the user name, uid, group name, gid and octal mode of the file, or nil if it does not exist.
+// Changes are described with "old owner/new owner", "old group/new group" and "old mode/new mode" lines,
+// like in git extended headers.
+func attributesDiff(currentAttributes []string, config *SendConfig) string {
+	var diff strings.Builder
+	exists := len(currentAttributes) >= 5
 
-	for _, host := range filteredHosts {
-		loopHost := host
-		go func() {
-			command := fmt.Sprintf("%sprintf '%s' > %s", preCommand, hostContent[loopHost.HostConfig.Host], destPath)
-			commandResponse := executeCommandOnHost(loopHost, command, &wg)
+	if config.Owner != "" {
+		if !exists {
+			fmt.Fprintf(&diff, "new owner %s\n", config.Owner)
+		} else if config.Owner != currentAttributes[0] && config.Owner != currentAttributes[1] {
+			fmt.Fprintf(&diff, "old owner %s\nnew owner %s\n", currentAttributes[0], config.Owner)
+		}
+	}
 
-			commandResponses[loopHost.HostConfig.Host] = commandResponse
-			if commandResponse.Error != nil || commandResponse.Stderr != "" {
-				errorHosts = append(errorHosts, loopHost.HostConfig.Host)
-			}
-		}()
+	if config.Group != "" {
+		if !exists {
+			fmt.Fprintf(&diff, "new group %s\n", config.Group)
+		} else if config.Group != currentAttributes[2] && config.Group != currentAttributes[3] {
+			fmt.Fprintf(&diff, "old group %s\nnew group %s\n", currentAttributes[2], config.Group)
+		}
 	}
 
-	wg.Wait()
+	if config.Mode != 0 {
+		mode := octalFileMode(config.Mode)
 
-	var commandError error
-	if len(errorHosts) > 0 {
-		commandError = fmt.Errorf("error on hosts: %v", errorHosts)
+		if !exists {
+			fmt.Fprintf(&diff, "new mode %s\n", mode)
+		} else if currentMode, err := strconv.ParseUint(currentAttributes[4], 8, 32); err != nil || fmt.Sprintf("%04o", currentMode) != mode {
+			fmt.Fprintf(&diff, "old mode %04o\nnew mode %s\n", currentMode, mode)
+		}
 	}
 
-	return &CommandResponses{HostResponses: commandResponses}, commandError
-
+	return diff.String()
 }
@@ -1,10 +1,13 @@
 package parallexe
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestSend(t *testing.T) {
@@ -110,7 +113,7 @@ func TestSend(t *testing.T) {
 			CompileTemplate: true,
 			ExecVariables:   &ExecVariables{Variables: KeyValueVariable{"Name": "tutu"}},
 			Owner:           "",
-			Mode:            "",
+			Mode:            0,
 			IgnoreIfExists:  false,
 		})
 		if err != nil {
@@ -130,6 +133,34 @@ func TestSend(t *testing.T) {
 		}
 
 	})
+
+	t.Run("Compile template from fs with partials", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"file.tpl":          {Data: []byte("{{ template \"name.tpl\" . }}\n")},
+			"partials/name.tpl": {Data: []byte("{{ .Name }}")},
+		}
+
+		destCopyFile := fmt.Sprintf("%s/%s", os.TempDir(), "file")
+		defer os.Remove(destCopyFile)
+
+		_, err := pexe.Send("file.tpl", destCopyFile, &SendConfig{
+			CompileTemplate: true,
+			TemplateFS:      fsys,
+			Partials:        []string{"partials/*.tpl"},
+			ExecVariables:   &ExecVariables{Variables: KeyValueVariable{"Name": "tutu"}},
+		})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+
+		content, err := os.ReadFile(destCopyFile)
+		if err != nil {
+			t.Fatalf("Error during file test reading: %v", err)
+		}
+		if string(content) != "tutu\n" {
+			t.Fatalf("File content is not correct")
+		}
+	})
 }
 
 func ExampleParallexe_Send_copy() {
@@ -203,3 +234,367 @@ func ExampleParallexe_Send_template() {
 	fmt.Println(string(content))
 	// Output: tutu
 }
+
+func TestSendTemplateFiltering(t *testing.T) {
+	pexe, err := New([]HostConfig{
+		{Host: "localhost", Groups: []string{"prod"}},
+		{Host: "127.0.0.1", Groups: []string{"uat"}},
+	})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	fsys := fstest.MapFS{
+		"file.tpl": {Data: []byte("{{ index .Db \"Name\" }}\n")},
+	}
+	execVariables := &ExecVariables{
+		GroupVariables: map[string]KeyValueVariable{
+			"prod": {"Db": map[string]interface{}{"Name": "prod_db"}},
+		},
+	}
+
+	t.Run("Render only filtered hosts", func(t *testing.T) {
+		destCopyFile := fmt.Sprintf("%s/%s", os.TempDir(), "file")
+		defer os.Remove(destCopyFile)
+
+		response, err := pexe.Send("file.tpl", destCopyFile, &SendConfig{
+			ExecConfig:      &ExecConfig{Groups: []string{"prod"}},
+			CompileTemplate: true,
+			TemplateFS:      fsys,
+			ExecVariables:   execVariables,
+		})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+		if len(response.HostResponses) != 1 {
+			t.Fatalf("Expected 1 host response, got %d", len(response.HostResponses))
+		}
+
+		content, err := os.ReadFile(destCopyFile)
+		if err != nil {
+			t.Fatalf("Error during file test reading: %v", err)
+		}
+		if string(content) != "prod_db\n" {
+			t.Fatalf("File content is not correct")
+		}
+	})
+
+	t.Run("Collect render errors per host", func(t *testing.T) {
+		destCopyFile := fmt.Sprintf("%s/%s", os.TempDir(), "file")
+		defer os.Remove(destCopyFile)
+
+		response, err := pexe.Send("file.tpl", destCopyFile, &SendConfig{
+			CompileTemplate: true,
+			TemplateFS:      fsys,
+			ExecVariables:   execVariables,
+		})
+		if err == nil {
+			t.Fatalf("Expected an error for host 127.0.0.1")
+		}
+		if len(response.HostResponses) != 2 {
+			t.Fatalf("Expected 2 host responses, got %d", len(response.HostResponses))
+		}
+		if response.HostResponses["127.0.0.1"].Error == nil {
+			t.Errorf("Expected a render error for host 127.0.0.1")
+		}
+		if !response.HostResponses["localhost"].Success {
+			t.Errorf("Expected file to be sent to localhost")
+		}
+	})
+}
+
+func TestSendCheck(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	file, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatalf("Error during file test creation: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("toto\ntutu\n")
+
+	destFile, err := os.CreateTemp("", "fileExists")
+	if err != nil {
+		t.Fatalf("Error during file test creation: %v", err)
+	}
+	defer os.Remove(destFile.Name())
+	destFile.WriteString("toto\ntata\n")
+	destFile.Chmod(0644)
+
+	t.Run("Check existing file", func(t *testing.T) {
+		response, err := pexe.Send(file.Name(), destFile.Name(), &SendConfig{
+			Mode:  0600,
+			Check: true,
+		})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+
+		for host, hostResponse := range response.HostResponses {
+			if !hostResponse.Changed {
+				t.Errorf("Expected host %s to be changed", host)
+			}
+			if !strings.Contains(hostResponse.Diff, "old mode 0644\nnew mode 0600\n") {
+				t.Errorf("Expected mode change in diff, got %q", hostResponse.Diff)
+			}
+			if !strings.Contains(hostResponse.Diff, "-tata\n+tutu\n") {
+				t.Errorf("Expected content change in diff, got %q", hostResponse.Diff)
+			}
+		}
+
+		diffGroups := response.GroupDiffs()
+		if len(diffGroups) != 1 || len(diffGroups[0].Hosts) != 2 {
+			t.Fatalf("Expected 1 diff group with 2 hosts, got %v", diffGroups)
+		}
+
+		// Check nothing has been written
+		content, err := os.ReadFile(destFile.Name())
+		if err != nil {
+			t.Fatalf("Error during file test reading: %v", err)
+		}
+		if string(content) != "toto\ntata\n" {
+			t.Fatalf("File content should not be changed")
+		}
+	})
+
+	t.Run("Check new file", func(t *testing.T) {
+		destCopyFile := fmt.Sprintf("%s/%s", os.TempDir(), "doesnotexist")
+
+		response, err := pexe.Send(file.Name(), destCopyFile, &SendConfig{
+			ExecConfig: &ExecConfig{Hosts: []string{"localhost"}},
+			Check:      true,
+		})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+
+		if !strings.HasPrefix(response.HostResponses["localhost"].Diff, "new file\n--- /dev/null\n") {
+			t.Errorf("Expected new file diff, got %q", response.HostResponses["localhost"].Diff)
+		}
+		if _, err := os.Stat(destCopyFile); !os.IsNotExist(err) {
+			t.Fatalf("File should not exist")
+		}
+	})
+
+	t.Run("Check unchanged file", func(t *testing.T) {
+		response, err := pexe.Send(destFile.Name(), destFile.Name(), &SendConfig{
+			Check: true,
+		})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+
+		for host, hostResponse := range response.HostResponses {
+			if hostResponse.Changed {
+				t.Errorf("Expected host %s not to be changed, got diff %q", host, hostResponse.Diff)
+			}
+		}
+	})
+}
+
+func TestSendAttributes(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	file, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatalf("Error during file test creation: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("toto\n")
+
+	t.Run("Apply owner, mode and create parents", func(t *testing.T) {
+		destDir, err := os.MkdirTemp("", "testdir")
+		if err != nil {
+			t.Fatalf("Error during directory test creation: %v", err)
+		}
+		defer os.RemoveAll(destDir)
+
+		destCopyFile := fmt.Sprintf("%s/parent/file", destDir)
+
+		_, err = pexe.Send(file.Name(), destCopyFile, &SendConfig{
+			Owner:         fmt.Sprintf("%d", os.Getuid()),
+			Group:         fmt.Sprintf("%d", os.Getgid()),
+			Mode:          0600,
+			CreateParents: true,
+			ParentMode:    0750,
+		})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+
+		fileInfo, err := os.Stat(destCopyFile)
+		if err != nil {
+			t.Fatalf("Error during file test stat: %v", err)
+		}
+		if fileInfo.Mode().Perm() != 0600 {
+			t.Errorf("Expected file mode 0600, got %v", fileInfo.Mode().Perm())
+		}
+
+		dirInfo, err := os.Stat(fmt.Sprintf("%s/parent", destDir))
+		if err != nil {
+			t.Fatalf("Error during directory test stat: %v", err)
+		}
+		if dirInfo.Mode().Perm() != 0750 {
+			t.Errorf("Expected directory mode 0750, got %v", dirInfo.Mode().Perm())
+		}
+
+		// Check no temporary file is left
+		entries, err := os.ReadDir(fmt.Sprintf("%s/parent", destDir))
+		if err != nil {
+			t.Fatalf("Error during directory test reading: %v", err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("Expected only the destination file, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("Keep mode of existing file", func(t *testing.T) {
+		destFile, err := os.CreateTemp("", "fileExists")
+		if err != nil {
+			t.Fatalf("Error during file test creation: %v", err)
+		}
+		defer os.Remove(destFile.Name())
+		destFile.Chmod(0640)
+
+		_, err = pexe.Send(file.Name(), destFile.Name(), &SendConfig{})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+
+		fileInfo, err := os.Stat(destFile.Name())
+		if err != nil {
+			t.Fatalf("Error during file test stat: %v", err)
+		}
+		if fileInfo.Mode().Perm() != 0640 {
+			t.Errorf("Expected file mode 0640, got %v", fileInfo.Mode().Perm())
+		}
+	})
+
+	t.Run("Invalid owner", func(t *testing.T) {
+		destCopyFile := fmt.Sprintf("%s/%s", os.TempDir(), "doesnotexist")
+		defer os.Remove(destCopyFile)
+
+		_, err := pexe.Send(file.Name(), destCopyFile, &SendConfig{
+			Owner: "root; touch /tmp/owned",
+		})
+		if err == nil {
+			t.Fatalf("Expected an error for an invalid owner")
+		}
+		if _, err := os.Stat(destCopyFile); !os.IsNotExist(err) {
+			t.Fatalf("File should not exist")
+		}
+	})
+}
+
+func TestBuiltinScriptsShell(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	dir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcFile := fmt.Sprintf("%s/src", dir)
+	if err := os.WriteFile(srcFile, []byte("toto\n"), 0644); err != nil {
+		t.Fatalf("Error during file test creation: %v", err)
+	}
+
+	// cat rejects the -c option, so the scripts fail if they are run by Shell
+	execConfig := &ExecConfig{Shell: "cat"}
+
+	t.Run("Send", func(t *testing.T) {
+		destFile := fmt.Sprintf("%s/send", dir)
+
+		_, err := pexe.Send(srcFile, destFile, &SendConfig{ExecConfig: execConfig, Check: true})
+		if err != nil {
+			t.Fatalf("Error during Send check: %v", err)
+		}
+
+		_, err = pexe.Send(srcFile, destFile, &SendConfig{ExecConfig: execConfig})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+
+		content, err := os.ReadFile(destFile)
+		if err != nil {
+			t.Fatalf("Error during file test reading: %v", err)
+		}
+		if string(content) != "toto\n" {
+			t.Errorf("Expected content %q, got %q", "toto\n", string(content))
+		}
+	})
+
+	t.Run("LineInFile", func(t *testing.T) {
+		destFile := fmt.Sprintf("%s/line", dir)
+
+		_, err := pexe.LineInFile(destFile, "tata", &LineInFileConfig{ExecConfig: execConfig})
+		if err != nil {
+			t.Fatalf("Error during LineInFile: %v", err)
+		}
+
+		content, err := os.ReadFile(destFile)
+		if err != nil {
+			t.Fatalf("Error during file test reading: %v", err)
+		}
+		if string(content) != "tata\n" {
+			t.Errorf("Expected content %q, got %q", "tata\n", string(content))
+		}
+	})
+}
+
+func TestSendCommandContent(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	destCopyFile := fmt.Sprintf("%s/%s", os.TempDir(), "secret.conf")
+	defer os.Remove(destCopyFile)
+
+	response, err := pexe.Send("secret.tpl", destCopyFile, &SendConfig{
+		CompileTemplate: true,
+		TemplateFS:      fstest.MapFS{"secret.tpl": {Data: []byte("password={{ .pw }}\n")}},
+		ExecVariables: &ExecVariables{
+			Variables: KeyValueVariable{"pw": "p4ssw0rd"},
+			Sensitive: []string{"pw"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error during Send: %v", err)
+	}
+
+	command := response.HostResponses["localhost"].Command
+	if !strings.Contains(command, "<18 bytes>") {
+		t.Errorf("Expected the content size in the command, got %q", command)
+	}
+
+	// No encoded value of the command may contain the secret
+	for _, word := range regexp.MustCompile(`[A-Za-z0-9+/]{4,}={0,2}`).FindAllString(command, -1) {
+		if decoded, err := base64.StdEncoding.DecodeString(word); err == nil && strings.Contains(string(decoded), "p4ssw0rd") {
+			t.Errorf("Expected the content not to be in the command, got %q", command)
+		}
+	}
+
+	content, err := os.ReadFile(destCopyFile)
+	if err != nil {
+		t.Fatalf("Error during file test reading: %v", err)
+	}
+	if string(content) != "password=p4ssw0rd\n" {
+		t.Errorf("Expected the rendered content in the file, got %q", string(content))
+	}
+}
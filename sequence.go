@@ -0,0 +1,495 @@
+package parallexe
+
+import (
+	"fmt"
+	"sync"
+	"text/template"
+)
+
+// Step is a command of a sequence run by MultiExecSteps or RunSequence, with its own settings
+type Step struct {
+	Command string
+	// Retry overrides ExecConfig.Retry for this step
+	Retry *RetryConfig
+	// Rollback is the command undoing Command. When the sequence fails on a host,
+	// the rollback commands of the steps done on this host are run in reverse order.
+	Rollback string
+	// When is a template condition, as written in {{ if }}, deciding whether the step is run on a host
+	// (e.g. `contains .Previous.Stdout "outdated"`, `eq .Previous.Code 1`).
+	// It gets the variables of the command templates and .Previous, the CommandResponse of the last step run on the host
+	// (nil before the first one, use `and .Previous ...` to test it).
+	// Hosts where the condition is false get the status CommandStatusConditionSkip and continue the sequence.
+	When string
+	// Register stores the result of the step on each host as a RegisteredResult variable with this name.
+	// It can then be used by the templates of the next steps and handlers (e.g. {{ .version.Stdout }}, {{ .status.Json.state }}).
+	// Once the sequence is done, the results are added to ExecConfig.ExecVariables.RegisteredVariables (if ExecVariables is set),
+	// to be used by Send templates and next sequences using the same ExecVariables.
+	// The result is redacted as the response (see ExecConfig.Redact and SecretRef).
+	Register string
+}
+
+// Handler identifies the MultiCommandResponses of the commands run after the steps of a sequence
+type Handler string
+
+const (
+	HandlerRollback  Handler = "rollback"
+	HandlerOnFailure Handler = "on_failure"
+	HandlerOnSuccess Handler = "on_success"
+	HandlerAlways    Handler = "always"
+)
+
+// Sequence is a list of steps with the handlers run after them
+type Sequence struct {
+	Steps []Step
+	// OnFailure steps are run on the hosts that did not complete the steps, after the rollback
+	OnFailure []Step
+	// OnSuccess steps are run on the hosts that completed all the steps
+	OnSuccess []Step
+	// Always steps are run on all hosts, after OnFailure and OnSuccess
+	Always []Step
+}
+
+// compiledStep contains a step with its command templates, condition and retry rules
+type compiledStep struct {
+	step         Step
+	hostCommand  func(hostConnection HostConnection) (string, error)
+	hostRollback func(hostConnection HostConnection) (string, error)
+	when         *template.Template
+	retryRules   *retryRules
+}
+
+// sequenceState contains the results of the steps run on each host, shared by the steps and handlers of a sequence
+type sequenceState struct {
+	m           sync.Mutex
+	failedHosts map[string]bool
+	// previous contains the response of the last step run on each host
+	previous map[string]*CommandResponse
+	// registered contains the results registered by the steps on each host
+	registered *registeredResults
+}
+
+func newSequenceState() *sequenceState {
+	return &sequenceState{
+		failedHosts: make(map[string]bool),
+		previous:    make(map[string]*CommandResponse),
+		registered:  newRegisteredResults(),
+	}
+}
+
+// MultiExecSteps executes a list of steps on a list of hosts as MultiExec, with the settings of each step.
+func (p *Parallexe) MultiExecSteps(steps []Step, execConfig *ExecConfig) ([]*MultiCommandResponses, error) {
+	return p.RunSequence(&Sequence{Steps: steps}, execConfig)
+}
+
+// RunSequence executes the steps of a sequence on a list of hosts as MultiExec, then its handlers:
+// on the hosts that did not complete the steps (failed, or stopped by StrategyAbortAll),
+// the rollback commands of the steps done are run in reverse order, then the OnFailure steps.
+// The OnSuccess steps are run on the other hosts, and the Always steps on all hosts.
+// A host stops running the steps of a handler list at its first failure.
+// The returned list contains the MultiCommandResponses of the steps, followed by the ones of the rollback commands
+// and of the handlers, identified by their Handler. The status of a step rolled back on a host is CommandStatusRolledBack.
+func (p *Parallexe) RunSequence(sequence *Sequence, execConfig *ExecConfig) ([]*MultiCommandResponses, error) {
+	strategy := StrategyAbortAll
+	if execConfig != nil && execConfig.Strategy != "" {
+		strategy = execConfig.Strategy
+	}
+	if strategy != StrategyAbortAll && strategy != StrategyContinue && strategy != StrategyFree {
+		return nil, fmt.Errorf("unknown strategy %q", strategy)
+	}
+
+	state := newSequenceState()
+	callerConfig := execConfig
+	execConfig = sequenceExecConfig(sequence, execConfig, state)
+
+	steps, err := compileSteps(sequence.Steps, execConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	handlers := make(map[Handler][]compiledStep)
+	for handler, handlerSteps := range map[Handler][]Step{
+		HandlerOnFailure: sequence.OnFailure,
+		HandlerOnSuccess: sequence.OnSuccess,
+		HandlerAlways:    sequence.Always,
+	} {
+		handlers[handler], err = compileSteps(handlerSteps, execConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// White list HostSession to execute only on desired hosts, collecting the secrets rendered by all the steps
+	filteredHosts := withSecretScopes(getFilteredHosts(p.HostConnections, execConfig))
+
+	execConfig, err = prepareCommandInput(execConfig, filteredHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	multiCommandResponses := runSteps(steps, filteredHosts, filteredHosts, execConfig, strategy, "", state)
+
+	// Split hosts between the ones which completed all the steps and the others
+	completedHosts := make([]HostConnection, 0)
+	incompleteHosts := make([]HostConnection, 0)
+	for _, host := range filteredHosts {
+		completed := true
+		for _, stepResponses := range multiCommandResponses {
+			if !statusCompleted(stepResponses.HostStatuses[host.HostConfig.Host]) {
+				completed = false
+				break
+			}
+		}
+
+		if completed {
+			completedHosts = append(completedHosts, host)
+		} else {
+			incompleteHosts = append(incompleteHosts, host)
+		}
+	}
+
+	multiCommandResponses = append(multiCommandResponses, rollbackSteps(steps, multiCommandResponses, filteredHosts, incompleteHosts, execConfig, state)...)
+	multiCommandResponses = append(multiCommandResponses, runSteps(handlers[HandlerOnFailure], filteredHosts, incompleteHosts, execConfig, StrategyContinue, HandlerOnFailure, state)...)
+	multiCommandResponses = append(multiCommandResponses, runSteps(handlers[HandlerOnSuccess], filteredHosts, completedHosts, execConfig, StrategyContinue, HandlerOnSuccess, state)...)
+	multiCommandResponses = append(multiCommandResponses, runSteps(handlers[HandlerAlways], filteredHosts, filteredHosts, execConfig, StrategyContinue, HandlerAlways, state)...)
+
+	errorHosts := make([]string, 0)
+	for _, host := range filteredHosts {
+		if state.failedHosts[host.HostConfig.Host] {
+			errorHosts = append(errorHosts, host.HostConfig.Host)
+		}
+	}
+
+	if callerConfig != nil && callerConfig.ExecVariables != nil {
+		state.registered.export(callerConfig.ExecVariables)
+	}
+
+	var commandError error
+	if len(errorHosts) > 0 {
+		commandError = fmt.Errorf("error on hosts: %v", errorHosts)
+	}
+
+	return multiCommandResponses, commandError
+}
+
+// compileSteps parses the command templates and compiles the retry rules of steps
+func compileSteps(steps []Step, execConfig *ExecConfig) ([]compiledStep, error) {
+	compiledSteps := make([]compiledStep, len(steps))
+
+	for index, step := range steps {
+		hostCommand, err := commandTemplate(step.Command, execConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		hostRollback, err := commandTemplate(step.Rollback, execConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		when, err := parseWhen(step.When)
+		if err != nil {
+			return nil, err
+		}
+
+		retry := step.Retry
+		if retry == nil && execConfig != nil {
+			retry = execConfig.Retry
+		}
+
+		rules, err := compileRetryRules(retry)
+		if err != nil {
+			return nil, err
+		}
+
+		compiledSteps[index] = compiledStep{
+			step:         step,
+			hostCommand:  hostCommand,
+			hostRollback: hostRollback,
+			when:         when,
+			retryRules:   rules,
+		}
+	}
+
+	return compiledSteps, nil
+}
+
+// runSteps runs steps on hosts with strategy, and returns a MultiCommandResponses per step tagged with handler.
+// Statuses are reported for all reportHosts, the ones not in hosts being skipped.
+// Hosts where a step fails are added to the failed hosts of state.
+func runSteps(steps []compiledStep, reportHosts []HostConnection, hosts []HostConnection, execConfig *ExecConfig, strategy Strategy, handler Handler, state *sequenceState) []*MultiCommandResponses {
+	multiCommandResponses := make([]*MultiCommandResponses, 0)
+
+	for _, step := range steps {
+		multiCommandResponses = append(multiCommandResponses, newMultiCommandResponses(step.step.Command, handler, reportHosts))
+	}
+
+	stepFailed := false
+
+	// runCommand runs a command on a host if its condition is met, and records its response and status.
+	// It returns false if the command failed.
+	runCommand := func(commandIndex int, hostConnection HostConnection) bool {
+		step := steps[commandIndex]
+		host := hostConnection.HostConfig.Host
+
+		run, err := state.evaluateWhen(step.when, hostConnection, execConfig)
+		if err == nil && !run {
+			state.m.Lock()
+			defer state.m.Unlock()
+
+			multiCommandResponses[commandIndex].HostStatuses[host] = CommandStatusConditionSkip
+			return true
+		}
+
+		commandResponse := executeWithMetadata(hostConnection, func() *CommandResponse {
+			if err != nil {
+				return newErrorResponse(err)
+			}
+
+			renderedCommand, err := step.hostCommand(hostConnection)
+			if err != nil {
+				return newErrorResponse(err)
+			}
+
+			return step.retryRules.execute(func() *CommandResponse {
+				return executeCommandOnHost(hostConnection, renderedCommand, execConfig)
+			})
+		})
+
+		failed := commandResponse.Error != nil || commandResponse.Stderr != ""
+
+		// The result is registered once redacted, as the responses of the playbook tasks,
+		// so that the secrets don't leak into ExecVariables.RegisteredVariables
+		commandResponse = hostConnection.redactResponse(commandResponse, redactConfig(execConfig))
+		if step.step.Register != "" {
+			state.registered.register(host, step.step.Register, newRegisteredResult(commandResponse))
+		}
+
+		state.m.Lock()
+		defer state.m.Unlock()
+
+		state.previous[host] = commandResponse
+		multiCommandResponses[commandIndex].HostResponses[host] = commandResponse
+		multiCommandResponses[commandIndex].HostStatuses[host] = CommandStatusDone
+		if failed {
+			multiCommandResponses[commandIndex].HostStatuses[host] = CommandStatusFailed
+			state.failedHosts[host] = true
+			stepFailed = true
+		}
+
+		return !failed
+	}
+
+	var wg sync.WaitGroup
+
+	if strategy == StrategyFree {
+		// Each host runs through the list independently
+		wg.Add(len(hosts))
+		for _, host := range hosts {
+			loopHost := host
+			go func() {
+				defer wg.Done()
+
+				for index := range steps {
+					if !runCommand(index, loopHost) {
+						return
+					}
+				}
+			}()
+		}
+
+		wg.Wait()
+	} else {
+		// All hosts run a command before the next one is started
+		activeHosts := hosts
+		for index := range steps {
+			if len(activeHosts) == 0 {
+				break
+			}
+
+			wg.Add(len(activeHosts))
+			for _, host := range activeHosts {
+				loopCommandIndex := index
+				loopHost := host
+				go func() {
+					defer wg.Done()
+					runCommand(loopCommandIndex, loopHost)
+				}()
+			}
+
+			wg.Wait()
+
+			if strategy == StrategyAbortAll && stepFailed {
+				break
+			}
+
+			nextHosts := make([]HostConnection, 0, len(activeHosts))
+			for _, host := range activeHosts {
+				if statusCompleted(multiCommandResponses[index].HostStatuses[host.HostConfig.Host]) {
+					nextHosts = append(nextHosts, host)
+				}
+			}
+			activeHosts = nextHosts
+		}
+	}
+
+	return multiCommandResponses
+}
+
+// rollbackSteps runs, in reverse order, the rollback commands of the steps done on hosts.
+// It returns a MultiCommandResponses per step having a rollback command, reporting statuses for all reportHosts.
+// The status of a step is set to CommandStatusRolledBack on the hosts where its rollback succeeded.
+// A host continues to roll back the previous steps when a rollback fails, and is added to the failed hosts of state.
+func rollbackSteps(steps []compiledStep, stepResponses []*MultiCommandResponses, reportHosts []HostConnection, hosts []HostConnection, execConfig *ExecConfig, state *sequenceState) []*MultiCommandResponses {
+	multiCommandResponses := make([]*MultiCommandResponses, 0)
+
+	for index := len(steps) - 1; index >= 0; index-- {
+		step := steps[index]
+		if step.step.Rollback == "" {
+			continue
+		}
+
+		rollbackResponses := newMultiCommandResponses(step.step.Rollback, HandlerRollback, reportHosts)
+		multiCommandResponses = append(multiCommandResponses, rollbackResponses)
+
+		doneHosts := make([]HostConnection, 0)
+		for _, host := range hosts {
+			if stepResponses[index].HostStatuses[host.HostConfig.Host] == CommandStatusDone {
+				doneHosts = append(doneHosts, host)
+			}
+		}
+
+		var wg sync.WaitGroup
+		for _, host := range doneHosts {
+			loopHost := host
+			loopStepResponses := stepResponses[index]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				commandResponse := executeWithMetadata(loopHost, func() *CommandResponse {
+					renderedCommand, err := step.hostRollback(loopHost)
+					if err != nil {
+						return newErrorResponse(err)
+					}
+
+					return executeCommandOnHost(loopHost, renderedCommand, execConfig)
+				})
+				commandResponse = loopHost.redactResponse(commandResponse, redactConfig(execConfig))
+
+				state.m.Lock()
+				defer state.m.Unlock()
+
+				host := loopHost.HostConfig.Host
+				rollbackResponses.HostResponses[host] = commandResponse
+				if commandResponse.Error != nil || commandResponse.Stderr != "" {
+					rollbackResponses.HostStatuses[host] = CommandStatusFailed
+					state.failedHosts[host] = true
+					return
+				}
+
+				rollbackResponses.HostStatuses[host] = CommandStatusDone
+				loopStepResponses.HostStatuses[host] = CommandStatusRolledBack
+			}()
+		}
+
+		wg.Wait()
+	}
+
+	return multiCommandResponses
+}
+
+// sequenceExecConfig returns a copy of execConfig whose ExecVariables get the results registered in state,
+// if a step of sequence registers its result
+func sequenceExecConfig(sequence *Sequence, execConfig *ExecConfig, state *sequenceState) *ExecConfig {
+	for _, steps := range [][]Step{sequence.Steps, sequence.OnFailure, sequence.OnSuccess, sequence.Always} {
+		for _, step := range steps {
+			if step.Register == "" {
+				continue
+			}
+
+			registerConfig := ExecConfig{}
+			if execConfig != nil {
+				registerConfig = *execConfig
+			}
+			registerVariables := ExecVariables{}
+			if registerConfig.ExecVariables != nil {
+				registerVariables = *registerConfig.ExecVariables
+			}
+			registerVariables.registered = state.registered
+			registerConfig.ExecVariables = &registerVariables
+
+			return &registerConfig
+		}
+	}
+
+	return execConfig
+}
+
+// parseWhen parses a When condition. It returns nil if when is empty.
+func parseWhen(when string) (*template.Template, error) {
+	if when == "" {
+		return nil, nil
+	}
+
+	return parseTextTemplate("when", fmt.Sprintf("{{ if %s }}true{{ end }}", when))
+}
+
+// evaluateWhen returns true if a condition parsed by parseWhen is met on a host, or if there is no condition
+func (state *sequenceState) evaluateWhen(when *template.Template, hostConnection HostConnection, execConfig *ExecConfig) (bool, error) {
+	if when == nil {
+		return true, nil
+	}
+
+	variables, err := state.variables(hostConnection, execConfig)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := renderTemplate(when, hostConnection.HostConfig.Host, variables)
+	if err != nil {
+		return false, err
+	}
+
+	return result == "true", nil
+}
+
+// variables returns the variables of the templates evaluated on a host during the sequence:
+// the variables of the command templates and .Previous
+func (state *sequenceState) variables(hostConnection HostConnection, execConfig *ExecConfig) (map[string]interface{}, error) {
+	var execVariables *ExecVariables
+	if execConfig != nil {
+		execVariables = execConfig.ExecVariables
+	}
+
+	variables, err := templateVariables(hostConnection, execVariables)
+	if err != nil {
+		return nil, err
+	}
+
+	state.m.Lock()
+	defer state.m.Unlock()
+
+	variables["Previous"] = state.previous[hostConnection.HostConfig.Host]
+
+	return variables, nil
+}
+
+// statusCompleted returns true if a step with status does not stop the sequence on a host
+func statusCompleted(status CommandStatus) bool {
+	return status == CommandStatusDone || status == CommandStatusConditionSkip
+}
+
+// newMultiCommandResponses returns the MultiCommandResponses of a command, skipped on all hosts
+func newMultiCommandResponses(command string, handler Handler, hosts []HostConnection) *MultiCommandResponses {
+	hostStatuses := make(map[string]CommandStatus)
+	for _, host := range hosts {
+		hostStatuses[host.HostConfig.Host] = CommandStatusSkip
+	}
+
+	return &MultiCommandResponses{
+		Command:       command,
+		Handler:       handler,
+		HostStatuses:  hostStatuses,
+		HostResponses: make(map[string]*CommandResponse),
+	}
+}
@@ -0,0 +1,269 @@
+package parallexe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSequence(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	dir, err := os.MkdirTemp("", "parallexe-sequence")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Each host logs the commands it runs in its own file
+	log := ShellQuote(filepath.Join(dir, "{{ .Host }}.log"))
+	readLog := func(host string) string {
+		content, _ := os.ReadFile(filepath.Join(dir, host+".log"))
+		return strings.TrimSpace(string(content))
+	}
+
+	sequence := &Sequence{
+		Steps: []Step{
+			{Command: fmt.Sprintf("echo step1 >> %s", log), Rollback: fmt.Sprintf("echo undo1 >> %s", log)},
+			{Command: fmt.Sprintf("echo step2 >> %s", log), Rollback: fmt.Sprintf("echo undo2 >> %s", log)},
+			// The third step fails on 127.0.0.1 only
+			{Command: fmt.Sprintf(`{{ if eq .Host "127.0.0.1" }}echo failed >&2{{ else }}echo step3 >> %s{{ end }}`, log), Rollback: fmt.Sprintf("echo undo3 >> %s", log)},
+		},
+		OnFailure: []Step{{Command: fmt.Sprintf("echo failure >> %s", log)}},
+		OnSuccess: []Step{{Command: fmt.Sprintf("echo success >> %s", log)}},
+		Always:    []Step{{Command: fmt.Sprintf("echo always >> %s", log)}},
+	}
+
+	t.Run("Failed host rolled back", func(t *testing.T) {
+		defer os.Remove(filepath.Join(dir, "localhost.log"))
+		defer os.Remove(filepath.Join(dir, "127.0.0.1.log"))
+
+		responses, err := pexe.RunSequence(sequence, &ExecConfig{CompileTemplate: true, Strategy: StrategyContinue})
+		if err == nil || err.Error() != "error on hosts: [127.0.0.1]" {
+			t.Fatalf("Expected an error on 127.0.0.1, got %v", err)
+		}
+
+		if readLog("localhost") != "step1\nstep2\nstep3\nsuccess\nalways" {
+			t.Errorf("Wrong commands on localhost, got %q", readLog("localhost"))
+		}
+		if readLog("127.0.0.1") != "step1\nstep2\nundo2\nundo1\nfailure\nalways" {
+			t.Errorf("Wrong commands on 127.0.0.1, got %q", readLog("127.0.0.1"))
+		}
+
+		// 3 steps, 3 rollbacks, 3 handlers
+		if len(responses) != 9 {
+			t.Fatalf("Expected 9 responses, got %d", len(responses))
+		}
+
+		expectedStatuses := []CommandStatus{CommandStatusRolledBack, CommandStatusRolledBack, CommandStatusFailed}
+		for index, status := range expectedStatuses {
+			if responses[index].HostStatuses["127.0.0.1"] != status {
+				t.Errorf("Expected status %s for step %d, got %s", status, index, responses[index].HostStatuses["127.0.0.1"])
+			}
+			if responses[index].HostStatuses["localhost"] != CommandStatusDone {
+				t.Errorf("Expected step %d to be done on localhost, got %s", index, responses[index].HostStatuses["localhost"])
+			}
+		}
+
+		expectedHandlers := []Handler{HandlerRollback, HandlerRollback, HandlerRollback, HandlerOnFailure, HandlerOnSuccess, HandlerAlways}
+		for index, handler := range expectedHandlers {
+			if responses[index+3].Handler != handler {
+				t.Errorf("Expected handler %s for response %d, got %s", handler, index+3, responses[index+3].Handler)
+			}
+		}
+
+		// undo3 is not run as step 3 failed
+		if responses[3].Command != sequence.Steps[2].Rollback || responses[3].HostStatuses["127.0.0.1"] != CommandStatusSkip {
+			t.Errorf("Expected rollback of step 3 to be skipped")
+		}
+	})
+
+	t.Run("Aborted hosts rolled back", func(t *testing.T) {
+		defer os.Remove(filepath.Join(dir, "localhost.log"))
+		defer os.Remove(filepath.Join(dir, "127.0.0.1.log"))
+
+		abortSequence := &Sequence{Steps: append([]Step{}, sequence.Steps...)}
+		abortSequence.Steps = append(abortSequence.Steps, Step{Command: fmt.Sprintf("echo step4 >> %s", log)})
+
+		_, err := pexe.RunSequence(abortSequence, &ExecConfig{CompileTemplate: true})
+		if err == nil || err.Error() != "error on hosts: [127.0.0.1]" {
+			t.Fatalf("Expected an error on 127.0.0.1, got %v", err)
+		}
+
+		if readLog("localhost") != "step1\nstep2\nstep3\nundo3\nundo2\nundo1" {
+			t.Errorf("Wrong commands on localhost, got %q", readLog("localhost"))
+		}
+	})
+}
+
+func TestRunSequenceWhen(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost", Groups: []string{"web"}}, {Host: "127.0.0.1", Groups: []string{"db"}}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	t.Run("Steps run on previous results", func(t *testing.T) {
+		responses, err := pexe.RunSequence(&Sequence{
+			Steps: []Step{
+				{Command: "echo never", When: `and .Previous false`},
+				{Command: "echo first", When: `not .Previous`},
+				{Command: "exit 1", When: `eq .role "web"`},
+				{Command: "echo upgrade", When: `eq .Previous.Code 1`},
+				{Command: "echo matched", When: `matches "^(upgrade|first)" .Previous.Stdout`},
+			},
+		}, &ExecConfig{
+			CompileTemplate: true,
+			ExecVariables: &ExecVariables{GroupVariables: map[string]KeyValueVariable{
+				"web": {"role": "web"},
+				"db":  {"role": "db"},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("Error during RunSequence: %v", err)
+		}
+
+		expected := map[string][]CommandStatus{
+			"localhost": {CommandStatusConditionSkip, CommandStatusDone, CommandStatusDone, CommandStatusDone, CommandStatusDone},
+			"127.0.0.1": {CommandStatusConditionSkip, CommandStatusDone, CommandStatusConditionSkip, CommandStatusConditionSkip, CommandStatusDone},
+		}
+
+		for host, statuses := range expected {
+			for index, status := range statuses {
+				if responses[index].HostStatuses[host] != status {
+					t.Errorf("Expected status %s for step %d on %s, got %s", status, index, host, responses[index].HostStatuses[host])
+				}
+			}
+		}
+	})
+
+	t.Run("Invalid condition", func(t *testing.T) {
+		_, err := pexe.RunSequence(&Sequence{Steps: []Step{{Command: "echo", When: "eq .Host )"}}}, nil)
+		if err == nil {
+			t.Fatalf("Expected an error for an invalid condition")
+		}
+	})
+
+	t.Run("Condition error fails the host", func(t *testing.T) {
+		responses, err := pexe.RunSequence(&Sequence{Steps: []Step{{Command: "echo", When: "eq .Previous.Code 0"}}}, &ExecConfig{Hosts: []string{"localhost"}})
+		if err == nil {
+			t.Fatalf("Expected an error for a nil previous response")
+		}
+
+		if responses[0].HostStatuses["localhost"] != CommandStatusFailed {
+			t.Errorf("Expected step to fail, got %s", responses[0].HostStatuses["localhost"])
+		}
+	})
+}
+
+func TestRunSequenceRegister(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}, {Host: "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+
+	t.Run("Registered results used by next steps and Send", func(t *testing.T) {
+		execVariables := &ExecVariables{}
+
+		responses, err := pexe.RunSequence(&Sequence{
+			Steps: []Step{
+				{Command: `echo '{"version": "1.{{ len .Host }}"}'`, Register: "release"},
+				{Command: "echo {{ .release.Json.version }}; exit 2", Register: "check"},
+				{Command: "echo upgrade", When: `eq .check.Code 2`},
+			},
+		}, &ExecConfig{CompileTemplate: true, ExecVariables: execVariables})
+		if err != nil {
+			t.Fatalf("Error during RunSequence: %v", err)
+		}
+
+		if responses[1].HostResponses["localhost"].Stdout != "1.9\n" {
+			t.Errorf("Expected registered JSON on localhost, got %q", responses[1].HostResponses["localhost"].Stdout)
+		}
+		if responses[1].HostResponses["127.0.0.1"].Stdout != "1.9\n" {
+			t.Errorf("Expected registered JSON on 127.0.0.1, got %q", responses[1].HostResponses["127.0.0.1"].Stdout)
+		}
+		if responses[2].HostStatuses["localhost"] != CommandStatusDone {
+			t.Errorf("Expected step to run on registered code, got %s", responses[2].HostStatuses["localhost"])
+		}
+
+		dir, err := os.MkdirTemp("", "parallexe-register")
+		if err != nil {
+			t.Fatalf("Error during directory test creation: %v", err)
+		}
+		defer os.RemoveAll(dir)
+
+		source := filepath.Join(dir, "version.tpl")
+		if err := os.WriteFile(source, []byte("{{ index .check.Lines 0 }}"), 0644); err != nil {
+			t.Fatalf("Error during template creation: %v", err)
+		}
+
+		dest := filepath.Join(dir, "version")
+		_, err = pexe.Send(source, dest, &SendConfig{
+			ExecConfig:      &ExecConfig{Hosts: []string{"localhost"}},
+			CompileTemplate: true,
+			ExecVariables:   execVariables,
+		})
+		if err != nil {
+			t.Fatalf("Error during Send: %v", err)
+		}
+
+		content, _ := os.ReadFile(dest)
+		if string(content) != "1.9" {
+			t.Errorf("Expected registered variable in sent template, got %q", string(content))
+		}
+	})
+
+	t.Run("Registered results are redacted", func(t *testing.T) {
+		execVariables := &ExecVariables{}
+
+		responses, err := pexe.RunSequence(&Sequence{
+			Steps: []Step{
+				{Command: "echo token=t0k3n", Register: "login"},
+				{Command: "echo {{ .login.Stdout }}"},
+			},
+		}, &ExecConfig{
+			Hosts:           []string{"localhost"},
+			CompileTemplate: true,
+			ExecVariables:   execVariables,
+			Redact:          &RedactConfig{Secrets: []string{"t0k3n"}},
+		})
+		if err != nil {
+			t.Fatalf("Error during RunSequence: %v", err)
+		}
+
+		if responses[1].HostResponses["localhost"].Stdout != "token=[REDACTED]\n" {
+			t.Errorf("Expected the next step to get the redacted result, got %q", responses[1].HostResponses["localhost"].Stdout)
+		}
+
+		registered, ok := execVariables.RegisteredVariables["localhost"]["login"].(*RegisteredResult)
+		if !ok {
+			t.Fatalf("Expected a registered result, got %v", execVariables.RegisteredVariables)
+		}
+		if registered.Stdout != "token=[REDACTED]\n" || strings.Contains(fmt.Sprint(registered.Lines), "t0k3n") {
+			t.Errorf("Expected the registered result to be redacted, got %+v", registered)
+		}
+	})
+
+	t.Run("Registered results without ExecVariables", func(t *testing.T) {
+		responses, err := pexe.RunSequence(&Sequence{
+			Steps: []Step{
+				{Command: "echo ready", Register: "status"},
+				{Command: "echo {{ .status.Stdout }}"},
+			},
+		}, &ExecConfig{Hosts: []string{"localhost"}, CompileTemplate: true})
+		if err != nil {
+			t.Fatalf("Error during RunSequence: %v", err)
+		}
+
+		if responses[1].HostResponses["localhost"].Stdout != "ready\n" {
+			t.Errorf("Expected registered stdout, got %q", responses[1].HostResponses["localhost"].Stdout)
+		}
+	})
+}
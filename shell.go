@@ -0,0 +1,85 @@
+package parallexe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// shellSafePattern matches words that don't need to be quoted in a POSIX shell
+var shellSafePattern = regexp.MustCompile(`^[A-Za-z0-9_@%+:,./-]+$`)
+
+// ShellQuote returns s quoted to be used as a single word in a POSIX shell command.
+// Words containing only safe characters are returned as is, others are single-quoted.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	if shellSafePattern.MatchString(s) {
+		return s
+	}
+
+	// A single quote can't be escaped in single quotes: close the quoted string, add an escaped quote, and reopen it
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ShellJoin quotes each argument with ShellQuote and joins them with spaces,
+// so the remote shell receives exactly args as argv
+func ShellJoin(args ...string) string {
+	quotedArgs := make([]string, len(args))
+	for index, arg := range args {
+		quotedArgs[index] = ShellQuote(arg)
+	}
+
+	return strings.Join(quotedArgs, " ")
+}
+
+// ExecArgs executes a command given as an argv slice on a list of hosts.
+// Each argument is quoted for the remote shell, so it is received as is by the command,
+// whatever characters it contains (spaces, quotes, $, ;, ...).
+// If execConfig.CompileTemplate is true, each argument is rendered for each host before being quoted,
+// so the rendered values are received as is too.
+func (p *Parallexe) ExecArgs(args []string, execConfig *ExecConfig) (*CommandResponses, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no command to execute")
+	}
+
+	argTemplates := make([]func(hostConnection HostConnection) (string, error), len(args))
+	for index, arg := range args {
+		if err := checkArg(arg); err != nil {
+			return nil, err
+		}
+
+		argTemplate, err := commandTemplate(arg, execConfig)
+		if err != nil {
+			return nil, err
+		}
+		argTemplates[index] = argTemplate
+	}
+
+	return p.execHostCommand(func(hostConnection HostConnection) (string, error) {
+		renderedArgs := make([]string, len(argTemplates))
+		for index, argTemplate := range argTemplates {
+			renderedArg, err := argTemplate(hostConnection)
+			if err != nil {
+				return "", err
+			}
+			if err := checkArg(renderedArg); err != nil {
+				return "", err
+			}
+			renderedArgs[index] = renderedArg
+		}
+
+		return ShellJoin(renderedArgs...), nil
+	}, execConfig)
+}
+
+// checkArg returns an error if arg can't be passed to a command, as it contains a NUL byte
+func checkArg(arg string) error {
+	if strings.ContainsRune(arg, 0) {
+		return fmt.Errorf("argument %q contains a NUL byte", arg)
+	}
+
+	return nil
+}
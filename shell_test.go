@@ -0,0 +1,181 @@
+package parallexe
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// hostileInputs contains strings that break or inject commands when they are not quoted
+var hostileInputs = []string{
+	"",
+	"simple",
+	"with space",
+	"it's",
+	"'",
+	"''",
+	`"double"`,
+	"$(touch /tmp/parallexe-injected)",
+	"`touch /tmp/parallexe-injected`",
+	"; touch /tmp/parallexe-injected",
+	"a && touch /tmp/parallexe-injected",
+	"| cat",
+	"$HOME",
+	"${HOME}",
+	"*",
+	"~",
+	"back\\slash",
+	"new\nline",
+	"tab\tchar",
+	"-n",
+	"FOO=bar",
+	"%s %d",
+	"héllo wörld",
+}
+
+func TestShellQuote(t *testing.T) {
+	t.Run("Safe words are not quoted", func(t *testing.T) {
+		for _, word := range []string{"ls", "/etc/hosts", "user@host:path", "--file", "a,b", "1.2.3"} {
+			if ShellQuote(word) != word {
+				t.Errorf("Expected %s not to be quoted, got %s", word, ShellQuote(word))
+			}
+		}
+	})
+
+	t.Run("Hostile inputs are received as is", func(t *testing.T) {
+		defer os.Remove("/tmp/parallexe-injected")
+
+		for _, input := range hostileInputs {
+			response := localExecute(HostConnection{HostConfig: HostConfig{Host: "localhost"}}, fmt.Sprintf("printf '%%s' %s", ShellQuote(input)), nil)
+			if response.Stdout != input {
+				t.Errorf("Expected %q, got %q", input, response.Stdout)
+			}
+		}
+
+		if _, err := os.Stat("/tmp/parallexe-injected"); !os.IsNotExist(err) {
+			t.Errorf("A command has been injected")
+		}
+	})
+}
+
+func TestShellJoin(t *testing.T) {
+	result := ShellJoin("grep", "-F", "it's here", "/etc/my file")
+	if result != `grep -F 'it'\''s here' '/etc/my file'` {
+		t.Errorf("Joined command is not correct, got %s", result)
+	}
+}
+
+func TestExecArgs(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+	defer os.Remove("/tmp/parallexe-injected")
+
+	args := append([]string{"printf", "%s\\n"}, hostileInputs...)
+	response, err := pexe.ExecArgs(args, nil)
+	if err != nil {
+		t.Fatalf("Error during ExecArgs: %v", err)
+	}
+
+	expected := strings.Join(hostileInputs, "\n") + "\n"
+	if response.HostResponses["localhost"].Stdout != expected {
+		t.Errorf("Expected %q, got %q", expected, response.HostResponses["localhost"].Stdout)
+	}
+
+	if _, err := os.Stat("/tmp/parallexe-injected"); !os.IsNotExist(err) {
+		t.Errorf("A command has been injected")
+	}
+
+	if _, err := pexe.ExecArgs([]string{}, nil); err == nil {
+		t.Errorf("Expected an error without arguments")
+	}
+
+	if _, err := pexe.ExecArgs([]string{"echo", "nul\x00byte"}, nil); err == nil {
+		t.Errorf("Expected an error for an argument with a NUL byte")
+	}
+}
+
+func TestHostileFileOperations(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+	defer os.Remove("/tmp/parallexe-injected")
+
+	dir, err := os.MkdirTemp("", "test dir")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := fmt.Sprintf("%s/it's $(id) `id`; -n.conf", dir)
+	line := "echo 'it''s' \"$HOME\" `id` ; %s \\n"
+
+	_, err = pexe.LineInFile(filePath, line, &LineInFileConfig{})
+	if err != nil {
+		t.Fatalf("Error during LineInFile: %v", err)
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Error during file test reading: %v", err)
+	}
+	if string(content) != line+"\n" {
+		t.Errorf("Expected %q, got %q", line+"\n", string(content))
+	}
+
+	sourceFile, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatalf("Error during file test creation: %v", err)
+	}
+	defer os.Remove(sourceFile.Name())
+	sourceFile.WriteString(line)
+
+	_, err = pexe.Send(sourceFile.Name(), filePath, &SendConfig{})
+	if err != nil {
+		t.Fatalf("Error during Send: %v", err)
+	}
+
+	content, err = os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Error during file test reading: %v", err)
+	}
+	if string(content) != line {
+		t.Errorf("Expected %q, got %q", line, string(content))
+	}
+
+	if _, err := os.Stat("/tmp/parallexe-injected"); !os.IsNotExist(err) {
+		t.Errorf("A command has been injected")
+	}
+}
+
+func TestExecArgsTemplate(t *testing.T) {
+	pexe, err := New([]HostConfig{{Host: "localhost"}})
+	if err != nil {
+		t.Fatalf("Error during Parallexe creation: %v", err)
+	}
+	defer pexe.Close()
+	defer os.Remove("/tmp/parallexe-injected")
+
+	hostile := "a'; touch /tmp/parallexe-injected; echo '"
+	response, err := pexe.ExecArgs([]string{"printf", "%s\\n", "{{ .value }}", "{{ .Host }}"}, &ExecConfig{
+		CompileTemplate: true,
+		ExecVariables:   &ExecVariables{Variables: KeyValueVariable{"value": hostile}},
+	})
+	if err != nil {
+		t.Fatalf("Error during ExecArgs: %v", err)
+	}
+
+	expected := hostile + "\nlocalhost\n"
+	if response.HostResponses["localhost"].Stdout != expected {
+		t.Errorf("Expected %q, got %q", expected, response.HostResponses["localhost"].Stdout)
+	}
+
+	if _, err := os.Stat("/tmp/parallexe-injected"); !os.IsNotExist(err) {
+		t.Errorf("A command has been injected")
+	}
+}
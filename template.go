@@ -0,0 +1,143 @@
+package parallexe
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// parseTemplate parses the template sourcePath and all partials matching the partials glob patterns.
+// If fsys is nil, files are read from the local disk.
+// Partials can be called from the source template with {{ template "name" . }} or {{ include "name" . }},
+// where name is the base name of the partial file or the name of a {{ define }} block.
+func parseTemplate(fsys fs.FS, sourcePath string, partials []string) (*template.Template, error) {
+	content, err := readTemplateFile(fsys, sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := newTemplate(filepath.Base(sourcePath))
+
+	for _, pattern := range partials {
+		matches, err := globTemplateFiles(fsys, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid partials pattern %s: %v", pattern, err)
+		}
+
+		for _, match := range matches {
+			partialContent, err := readTemplateFile(fsys, match)
+			if err != nil {
+				return nil, err
+			}
+
+			_, err = tmpl.New(filepath.Base(match)).Parse(string(partialContent))
+			if err != nil {
+				return nil, fmt.Errorf("can't parse partial %s: %v", match, err)
+			}
+		}
+	}
+
+	// Parse the source template last, so its definitions override partials ones
+	_, err = tmpl.Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("can't parse template %s: %v", sourcePath, err)
+	}
+
+	return tmpl, nil
+}
+
+// parseTextTemplate parses a template given as a string
+func parseTextTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := newTemplate(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse template %s: %v", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// newTemplate creates an empty template with the functions available in all templates
+func newTemplate(name string) *template.Template {
+	tmpl := template.New(name)
+	tmpl.Funcs(template.FuncMap{
+		// include executes a named template and returns its result as a string,
+		// so it can be piped to other functions (unlike the template action)
+		"include": func(name string, data interface{}) (string, error) {
+			var rendered bytes.Buffer
+			err := tmpl.ExecuteTemplate(&rendered, name, data)
+			return rendered.String(), err
+		},
+		// quote quotes a value to be used as a single word in a shell command
+		"quote": func(value interface{}) string {
+			return ShellQuote(fmt.Sprint(value))
+		},
+		"contains":  strings.Contains,
+		"hasPrefix": strings.HasPrefix,
+		"hasSuffix": strings.HasSuffix,
+		// matches reports whether s contains a match of the regular expression pattern
+		"matches": func(pattern string, s string) (bool, error) {
+			return regexp.MatchString(pattern, s)
+		},
+	})
+
+	return tmpl
+}
+
+// renderTemplate executes tmpl with the variables of a host.
+// The returned error contains the host and the template line that failed.
+func renderTemplate(tmpl *template.Template, host string, variables map[string]interface{}) (string, error) {
+	var rendered bytes.Buffer
+	err := tmpl.Execute(&rendered, variables)
+	if err != nil {
+		return "", fmt.Errorf("can't render template on host %s: %v", host, err)
+	}
+
+	return rendered.String(), nil
+}
+
+// commandTemplate returns a function building the command run on each host.
+// If execConfig.CompileTemplate is true, command is parsed as a template rendered per host with templateVariables,
+// otherwise it is returned as is.
+func commandTemplate(command string, execConfig *ExecConfig) (func(hostConnection HostConnection) (string, error), error) {
+	if execConfig == nil || !execConfig.CompileTemplate {
+		return func(hostConnection HostConnection) (string, error) {
+			return command, nil
+		}, nil
+	}
+
+	tmpl, err := parseTextTemplate("command", command)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostConnection HostConnection) (string, error) {
+		variables, err := templateVariables(hostConnection, execConfig.ExecVariables)
+		if err != nil {
+			return "", err
+		}
+		return renderTemplate(tmpl, hostConnection.HostConfig.Host, variables)
+	}, nil
+}
+
+// readTemplateFile reads a file from fsys, or from the local disk if fsys is nil
+func readTemplateFile(fsys fs.FS, path string) ([]byte, error) {
+	if fsys == nil {
+		return os.ReadFile(path)
+	}
+
+	return fs.ReadFile(fsys, path)
+}
+
+// globTemplateFiles returns the files matching pattern in fsys, or in the local disk if fsys is nil
+func globTemplateFiles(fsys fs.FS, pattern string) ([]string, error) {
+	if fsys == nil {
+		return filepath.Glob(pattern)
+	}
+
+	return fs.Glob(fsys, pattern)
+}
@@ -0,0 +1,94 @@
+package parallexe
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseTemplate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"templates/app.conf.tpl": {Data: []byte("{{ template \"header\" . }}name={{ .Name }}\n{{ include \"footer.tpl\" . | printf \"%s\" }}")},
+		"partials/header.tpl":    {Data: []byte("{{ define \"header\" }}# {{ .Title }}\n{{ end }}")},
+		"partials/footer.tpl":    {Data: []byte("# end of {{ .Name }}")},
+	}
+
+	t.Run("Render with partials", func(t *testing.T) {
+		tmpl, err := parseTemplate(fsys, "templates/app.conf.tpl", []string{"partials/*.tpl"})
+		if err != nil {
+			t.Fatalf("Error during template parsing: %v", err)
+		}
+
+		rendered, err := renderTemplate(tmpl, "localhost", map[string]interface{}{"Name": "app", "Title": "App"})
+		if err != nil {
+			t.Fatalf("Error during template rendering: %v", err)
+		}
+
+		if rendered != "# App\nname=app\n# end of app" {
+			t.Errorf("Rendered template is not correct, got %q", rendered)
+		}
+	})
+
+	t.Run("Values are not HTML escaped", func(t *testing.T) {
+		textFS := fstest.MapFS{
+			"text.tpl": {Data: []byte("url={{ .Url }}\nquote={{ .Quote }}")},
+		}
+
+		tmpl, err := parseTemplate(textFS, "text.tpl", nil)
+		if err != nil {
+			t.Fatalf("Error during template parsing: %v", err)
+		}
+
+		rendered, err := renderTemplate(tmpl, "localhost", map[string]interface{}{"Url": "http://a/?b=1&c=<2>", "Quote": `"it's"`})
+		if err != nil {
+			t.Fatalf("Error during template rendering: %v", err)
+		}
+
+		if rendered != "url=http://a/?b=1&c=<2>\nquote=\"it's\"" {
+			t.Errorf("Expected unescaped values, got %q", rendered)
+		}
+	})
+
+	t.Run("Missing source", func(t *testing.T) {
+		_, err := parseTemplate(fsys, "templates/missing.tpl", nil)
+		if err == nil {
+			t.Fatalf("Expected an error for a missing template")
+		}
+	})
+
+	t.Run("Parse error contains line", func(t *testing.T) {
+		brokenFS := fstest.MapFS{
+			"broken.tpl": {Data: []byte("line1\n{{ .Name ")},
+		}
+
+		_, err := parseTemplate(brokenFS, "broken.tpl", nil)
+		if err == nil {
+			t.Fatalf("Expected a parse error")
+		}
+		if !strings.Contains(err.Error(), "broken.tpl:2") {
+			t.Errorf("Expected error to contain the template line, got %v", err)
+		}
+	})
+
+	t.Run("Execution error contains host and line", func(t *testing.T) {
+		execFS := fstest.MapFS{
+			"exec.tpl": {Data: []byte("line1\n{{ .Name.Sub }}")},
+		}
+
+		tmpl, err := parseTemplate(execFS, "exec.tpl", nil)
+		if err != nil {
+			t.Fatalf("Error during template parsing: %v", err)
+		}
+
+		_, err = renderTemplate(tmpl, "100.0.0.1", map[string]interface{}{"Name": 1})
+		if err == nil {
+			t.Fatalf("Expected an execution error")
+		}
+		if !strings.Contains(err.Error(), "100.0.0.1") {
+			t.Errorf("Expected error to contain the host, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "exec.tpl:2") {
+			t.Errorf("Expected error to contain the template line, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package parallexe
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	// userGroupPattern matches a user or group name, or a numeric id
+	userGroupPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*\$?$`)
+	// selinuxContextPattern matches a SELinux security context (user:role:type:level)
+	selinuxContextPattern = regexp.MustCompile(`^[A-Za-z0-9_.:,-]+$`)
+)
+
+// fileAttributes contains the attributes applied to a file written on a host
+type fileAttributes struct {
+	Owner          string
+	Group          string
+	Mode           os.FileMode
+	SELinuxContext string
+	CreateParents  bool
+	ParentMode     os.FileMode
+}
+
+// validate checks the attributes before they are used in a command
+func (a fileAttributes) validate() error {
+	if a.Owner != "" && !userGroupPattern.MatchString(a.Owner) {
+		return fmt.Errorf("invalid owner %q", a.Owner)
+	}
+
+	if a.Group != "" && !userGroupPattern.MatchString(a.Group) {
+		return fmt.Errorf("invalid group %q", a.Group)
+	}
+
+	if a.SELinuxContext != "" && !selinuxContextPattern.MatchString(a.SELinuxContext) {
+		return fmt.Errorf("invalid SELinux context %q", a.SELinuxContext)
+	}
+
+	if err := validateFileMode(a.Mode); err != nil {
+		return err
+	}
+
+	return validateFileMode(a.ParentMode)
+}
+
+// validateFileMode checks that mode only contains permission, setuid, setgid and sticky bits
+func validateFileMode(mode os.FileMode) error {
+	if mode&^(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 {
+		return fmt.Errorf("invalid mode %v: only permission, setuid, setgid and sticky bits are allowed", mode)
+	}
+
+	return nil
+}
+
+// octalFileMode returns mode in the octal notation used by chmod (e.g. 0644, 4755)
+func octalFileMode(mode os.FileMode) string {
+	octal := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		octal |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		octal |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		octal |= 01000
+	}
+
+	return fmt.Sprintf("%04o", octal)
+}
+
+// maxSymlinks is the maximum number of symbolic links followed to find the file written by writeFileCommand
+const maxSymlinks = 40
+
+// encodeFileContent returns content encoded in base64, as written in the commands of writeFileCommand
+func encodeFileContent(content string) string {
+	return base64.StdEncoding.EncodeToString([]byte(content))
+}
+
+// hideFileContent replaces the encoded content in the Command of a response to writeFileCommand by its size,
+// so the content written, which may contain secrets, is not returned
+func hideFileContent(commandResponse *CommandResponse, content string) *CommandResponse {
+	if commandResponse == nil || content == "" {
+		return commandResponse
+	}
+
+	commandResponse.Command = strings.ReplaceAll(commandResponse.Command, encodeFileContent(content), fmt.Sprintf("<%d bytes>", len(content)))
+
+	return commandResponse
+}
+
+// writeFileCommand returns a shell script writing content to destPath atomically:
+// content is written to a temporary file in the same directory, attributes are applied to it,
+// then it is moved to destPath. The temporary file is removed if any step fails.
+// If destPath is a symbolic link, the file it points to is written and the link is kept.
+// If the destination file exists, its mode and owner are kept unless attributes override them.
+// If ignoreIfExists is true, nothing is done when destPath already exists.
+// The responses to the command must be passed to hideFileContent.
+func writeFileCommand(destPath string, content string, attributes fileAttributes, ignoreIfExists bool) string {
+	dir := path.Dir(destPath)
+
+	commands := make([]string, 0)
+
+	if ignoreIfExists {
+		commands = append(commands, fmt.Sprintf("[ -f %s ] && exit 0", ShellQuote(destPath)))
+	}
+
+	if attributes.CreateParents {
+		if attributes.ParentMode != 0 {
+			commands = append(commands, fmt.Sprintf("mkdir -p -m %s -- %s || exit 1", octalFileMode(attributes.ParentMode), ShellQuote(dir)))
+		} else {
+			commands = append(commands, fmt.Sprintf("mkdir -p -- %s || exit 1", ShellQuote(dir)))
+		}
+	}
+
+	commands = append(commands,
+		fmt.Sprintf("dest=%s", ShellQuote(destPath)),
+		// Follow the symbolic links, readlink -f is not available everywhere
+		fmt.Sprintf(`links=0
+while [ -L "$dest" ]; do
+  links=$((links + 1))
+  [ "$links" -gt %d ] && { echo "too many levels of symbolic links: $dest" >&2; exit 1; }
+  link=$(readlink -- "$dest") || exit 1
+  case "$link" in
+    /*) dest="$link" ;;
+    *) dest="$(dirname -- "$dest")/$link" ;;
+  esac
+done`, maxSymlinks),
+		fmt.Sprintf(`tmp="$(dirname -- "$dest")/.$(basename -- "$dest").parallexe-%s"`, randomSuffix()),
+		`trap 'rm -f "$tmp"' EXIT`,
+		// Content is base64 encoded so it is written as is, whatever bytes it contains
+		fmt.Sprintf(`(set -C; printf '%%s' '%s' | base64 -d > "$tmp") || exit 1`, encodeFileContent(content)),
+		// Keep the owner and mode of the existing file, read as user, uid, group, gid and mode
+		fmt.Sprintf(`if [ -e "$dest" ]; then
+  set -- $(%s) && [ $# -eq 5 ] || exit 1
+  chown "$2:$4" "$tmp" || exit 1
+  chmod "$5" "$tmp" || exit 1
+fi`, statCommand(`"$dest"`)),
+	)
+
+	if attributes.Owner != "" || attributes.Group != "" {
+		owner := attributes.Owner
+		if attributes.Group != "" {
+			owner = fmt.Sprintf("%s:%s", attributes.Owner, attributes.Group)
+		}
+		commands = append(commands, fmt.Sprintf(`chown %s "$tmp" || exit 1`, ShellQuote(owner)))
+	}
+
+	if attributes.Mode != 0 {
+		commands = append(commands, fmt.Sprintf(`chmod %s "$tmp" || exit 1`, octalFileMode(attributes.Mode)))
+	}
+
+	if attributes.SELinuxContext != "" {
+		commands = append(commands, fmt.Sprintf(`chcon %s "$tmp" || exit 1`, ShellQuote(attributes.SELinuxContext)))
+	}
+
+	commands = append(commands, `mv -f -- "$tmp" "$dest"`)
+
+	return strings.Join(commands, "\n")
+}
+
+// randomSuffix returns a random string used to name temporary files
+func randomSuffix() string {
+	bytes := make([]byte, 8)
+	_, _ = rand.Read(bytes)
+
+	return hex.EncodeToString(bytes)
+}
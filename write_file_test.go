@@ -0,0 +1,104 @@
+package parallexe
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileAttributesValidate(t *testing.T) {
+	validAttributes := []fileAttributes{
+		{},
+		{Owner: "www-data", Group: "www-data", Mode: 0644},
+		{Owner: "1000", Group: "1000"},
+		{Owner: "machine$", Mode: 0755 | os.ModeSetuid | os.ModeSticky},
+		{SELinuxContext: "system_u:object_r:httpd_sys_content_t:s0:c0,c1"},
+		{CreateParents: true, ParentMode: 0750},
+	}
+	for _, attributes := range validAttributes {
+		if err := attributes.validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", attributes, err)
+		}
+	}
+
+	invalidAttributes := []fileAttributes{
+		{Owner: "root; rm -rf /"},
+		{Owner: "-R"},
+		{Group: "wheel adm"},
+		{Mode: os.ModeDir | 0755},
+		{SELinuxContext: "system_u:object_r:etc_t:s0'"},
+		{ParentMode: os.ModeSymlink},
+	}
+	for _, attributes := range invalidAttributes {
+		if err := attributes.validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", attributes)
+		}
+	}
+}
+
+func TestOctalFileMode(t *testing.T) {
+	modes := map[os.FileMode]string{
+		0644:                 "0644",
+		0600:                 "0600",
+		0755 | os.ModeSetuid: "4755",
+		0775 | os.ModeSetgid: "2775",
+		0777 | os.ModeSticky: "1777",
+		os.ModePerm:          "0777",
+		0:                    "0000",
+	}
+
+	for mode, expected := range modes {
+		if result := octalFileMode(mode); result != expected {
+			t.Errorf("Expected %v to be %s, got %s", mode, expected, result)
+		}
+	}
+}
+
+func TestWriteFileCommand(t *testing.T) {
+	dir, err := os.MkdirTemp("", "parallexe-write")
+	if err != nil {
+		t.Fatalf("Error during directory test creation: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostConnection := HostConnection{HostConfig: HostConfig{Host: "localhost"}}
+
+	realPath := filepath.Join(dir, "real.conf")
+	if err := os.WriteFile(realPath, []byte("old\n"), 0600); err != nil {
+		t.Fatalf("Error during file creation: %v", err)
+	}
+	if err := os.Chmod(realPath, 0600); err != nil {
+		t.Fatalf("Error during file chmod: %v", err)
+	}
+	linkPath := filepath.Join(dir, "link.conf")
+	if err := os.Symlink("real.conf", linkPath); err != nil {
+		t.Fatalf("Error during link creation: %v", err)
+	}
+
+	commandResponse := executeCommandOnHost(hostConnection, writeFileCommand(linkPath, "new\n", fileAttributes{}, false), nil)
+	if commandResponse.Error != nil || commandResponse.Stderr != "" || commandResponse.Code != 0 {
+		t.Fatalf("Error during file writing: %+v", commandResponse)
+	}
+
+	if info, err := os.Lstat(linkPath); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected the link to be kept, got %v", info)
+	}
+	if content, _ := os.ReadFile(realPath); string(content) != "new\n" {
+		t.Errorf("Expected the link target to be written, got %q", string(content))
+	}
+	if info, _ := os.Stat(realPath); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the mode to be kept, got %v", info.Mode())
+	}
+
+	t.Run("Symbolic link loop", func(t *testing.T) {
+		loopPath := filepath.Join(dir, "loop")
+		if err := os.Symlink("loop", loopPath); err != nil {
+			t.Fatalf("Error during link creation: %v", err)
+		}
+
+		commandResponse := executeCommandOnHost(hostConnection, writeFileCommand(loopPath, "new\n", fileAttributes{}, false), nil)
+		if commandResponse.Stderr == "" || commandResponse.Code == 0 {
+			t.Errorf("Expected an error, got %+v", commandResponse)
+		}
+	})
+}